@@ -0,0 +1,273 @@
+package ginkeycloak
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/go-jose/go-jose.v2"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+)
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// DefaultRenewBefore is used if ClientCredentialsConfig.RenewBefore is not set
+var DefaultRenewBefore = time.Minute
+
+// ClientAssertionLifetime is the validity of the signed client assertions of private_key_jwt
+var ClientAssertionLifetime = time.Minute
+
+// TokenRetryBackoff is the wait after a failed client credentials request, doubled with every
+// further failure up to TokenMaxRetryBackoff. Until then a still valid token is used and callers
+// without one get the last error instead of another request.
+var TokenRetryBackoff = time.Second
+var TokenMaxRetryBackoff = time.Minute
+
+var ErrClientCredentials = errors.New("Client credentials grant failed")
+
+// ClientCredentialsConfig configures tokens of a service account. The client authenticates with
+// Certificate (tls_client_auth) if set, else with a JWT signed by PrivateKey (private_key_jwt) if
+// set, else with ClientSecret (client_secret_basic).
+type ClientCredentialsConfig struct {
+	KeycloakConfig KeycloakConfig
+	ClientID       string
+	ClientSecret   string
+	// PrivateKey signs the client assertion, its KeyID has to match the key registered in Keycloak
+	PrivateKey *jose.JSONWebKey
+	// Certificate is the TLS client certificate registered for the client
+	Certificate *tls.Certificate
+	Scopes      []string
+	// RenewBefore is how long before expiry the next Token call requests a new token while
+	// returning the current one, defaults to DefaultRenewBefore
+	RenewBefore time.Duration
+}
+
+type tokenCall struct {
+	done  chan struct{}
+	token *oauth2.Token
+	err   error
+}
+
+// ClientCredentialsTokenSource is an oauth2.TokenSource for the service account of a client
+type ClientCredentialsTokenSource struct {
+	config     ClientCredentialsConfig
+	tokenURL   string
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	token    *oauth2.Token
+	call     *tokenCall
+	failures int
+	retryAt  time.Time
+	lastErr  error
+}
+
+// NewClientCredentialsTokenSource returns a token source for the service account of the client.
+// Shortly before the token expires a call starts the renewal and still gets the current token,
+// concurrent callers share one request, limited by TokenRequestTimeout. Failed requests are retried
+// after TokenRetryBackoff. Use oauth2.NewClient to get an http.Client sending the tokens.
+func NewClientCredentialsTokenSource(config ClientCredentialsConfig) (*ClientCredentialsTokenSource, error) {
+	tokenURL, err := realmURL(config.KeycloakConfig, "protocol/openid-connect/token")
+	if err != nil {
+		return nil, err
+	}
+	if config.RenewBefore == 0 {
+		config.RenewBefore = DefaultRenewBefore
+	}
+	httpClient := config.KeycloakConfig.HTTPClient
+	if config.Certificate != nil {
+		if httpClient, err = tlsClient(httpClient, *config.Certificate); err != nil {
+			return nil, err
+		}
+	}
+	if config.PrivateKey != nil && config.PrivateKey.Algorithm == "" {
+		key := *config.PrivateKey
+		if key.Algorithm, err = signingAlgorithm(key.Key); err != nil {
+			return nil, err
+		}
+		config.PrivateKey = &key
+	}
+	return &ClientCredentialsTokenSource{config: config, tokenURL: tokenURL, httpClient: httpClient}, nil
+}
+
+// tlsClient copies the client with the certificate added to its transport
+func tlsClient(httpClient *http.Client, certificate tls.Certificate) (*http.Client, error) {
+	var transport *http.Transport
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	switch base := httpClient.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = base.Clone()
+	default:
+		return nil, errors.New("mTLS client authentication requires an *http.Transport")
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+	client := *httpClient
+	client.Transport = transport
+	return &client, nil
+}
+
+func signingAlgorithm(key interface{}) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return string(jose.RS256), nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return string(jose.ES256), nil
+		case elliptic.P384():
+			return string(jose.ES384), nil
+		case elliptic.P521():
+			return string(jose.ES512), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported client assertion key %T", key)
+}
+
+// Token returns a valid token, waiting at most TokenRequestTimeout if a new one has to be requested
+func (s *ClientCredentialsTokenSource) Token() (*oauth2.Token, error) {
+	return s.TokenContext(context.Background())
+}
+
+// TokenContext is Token, waiting for a new token only until ctx is done. The request itself is
+// shared with concurrent callers and not canceled with ctx.
+func (s *ClientCredentialsTokenSource) TokenContext(ctx context.Context) (*oauth2.Token, error) {
+	s.mu.Lock()
+	token := s.token
+	if token != nil && (token.Expiry.IsZero() || time.Until(token.Expiry) > s.config.RenewBefore) {
+		s.mu.Unlock()
+		return copyToken(token), nil
+	}
+	call := s.call
+	if call == nil {
+		if time.Now().Before(s.retryAt) {
+			err := s.lastErr
+			s.mu.Unlock()
+			if token != nil && token.Valid() {
+				return copyToken(token), nil
+			}
+			return nil, err
+		}
+		call = &tokenCall{done: make(chan struct{})}
+		s.call = call
+		go s.fetch(call)
+	}
+	s.mu.Unlock()
+
+	if token != nil && token.Valid() {
+		return copyToken(token), nil
+	}
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %s", ErrClientCredentials, ctx.Err())
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	return copyToken(call.token), nil
+}
+
+func copyToken(token *oauth2.Token) *oauth2.Token {
+	copied := *token
+	return &copied
+}
+
+func (s *ClientCredentialsTokenSource) fetch(call *tokenCall) {
+	call.token, call.err = s.requestToken()
+	if call.err != nil {
+		glog.Errorf("[Gin-OAuth] %s", call.err)
+	}
+	s.mu.Lock()
+	if call.err == nil {
+		s.token = call.token
+		s.failures, s.retryAt, s.lastErr = 0, time.Time{}, nil
+	} else {
+		s.failures++
+		s.retryAt = time.Now().Add(tokenRetryBackoff(s.failures))
+		s.lastErr = call.err
+	}
+	s.call = nil
+	s.mu.Unlock()
+	close(call.done)
+}
+
+func tokenRetryBackoff(failures int) time.Duration {
+	backoff := TokenRetryBackoff
+	for i := 1; i < failures && backoff < TokenMaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > TokenMaxRetryBackoff {
+		backoff = TokenMaxRetryBackoff
+	}
+	return backoff
+}
+
+func (s *ClientCredentialsTokenSource) requestToken() (*oauth2.Token, error) {
+	params := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.config.Scopes) > 0 {
+		params.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+	clientSecret := ""
+	switch {
+	case s.config.Certificate != nil:
+		params.Set("client_id", s.config.ClientID)
+	case s.config.PrivateKey != nil:
+		assertion, err := s.clientAssertion()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrClientCredentials, err)
+		}
+		params.Set("client_id", s.config.ClientID)
+		params.Set("client_assertion_type", clientAssertionType)
+		params.Set("client_assertion", assertion)
+	default:
+		clientSecret = s.config.ClientSecret
+	}
+
+	token, err := postTokenRequest(context.Background(), s.httpClient, s.tokenURL, params, s.config.ClientID, clientSecret)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrClientCredentials, err)
+	}
+	return token, nil
+}
+
+// clientAssertion signs a JWT authenticating the client at the token endpoint (RFC 7523)
+func (s *ClientCredentialsTokenSource) clientAssertion() (string, error) {
+	key := s.config.PrivateKey
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.SignatureAlgorithm(key.Algorithm), Key: key},
+		(&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", err
+	}
+	jti, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:   s.config.ClientID,
+		Subject:  s.config.ClientID,
+		Audience: jwt.Audience{s.tokenURL},
+		ID:       jti,
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(ClientAssertionLifetime)),
+	}
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
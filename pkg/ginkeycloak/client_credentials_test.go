@@ -0,0 +1,203 @@
+package ginkeycloak
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	jose "gopkg.in/go-jose/go-jose.v2"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+)
+
+type fakeTokenEndpoint struct {
+	server    *httptest.Server
+	calls     int32
+	expiresIn int
+	check     func(r *http.Request)
+}
+
+func newFakeTokenEndpoint(check func(r *http.Request)) *fakeTokenEndpoint {
+	endpoint := &fakeTokenEndpoint{expiresIn: 300, check: check}
+	endpoint.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls := atomic.AddInt32(&endpoint.calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		if r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		endpoint.check(r)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "service-token-" + strconv.Itoa(int(calls)),
+			"token_type":   "Bearer",
+			"expires_in":   endpoint.expiresIn,
+		})
+	}))
+	return endpoint
+}
+
+func Test_ClientCredentials_secret(t *testing.T) {
+	endpoint := newFakeTokenEndpoint(func(r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		assert.Equal(t, "worker", clientID)
+		assert.Equal(t, "secret", clientSecret)
+		assert.Equal(t, "jobs", r.FormValue("scope"))
+	})
+	defer endpoint.server.Close()
+	source, err := NewClientCredentialsTokenSource(ClientCredentialsConfig{
+		KeycloakConfig: KeycloakConfig{Url: endpoint.server.URL, Realm: "test"},
+		ClientID:       "worker",
+		ClientSecret:   "secret",
+		Scopes:         []string{"jobs"},
+	})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := source.Token()
+			assert.NoError(t, err)
+			assert.Equal(t, "service-token-1", token.AccessToken)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&endpoint.calls))
+}
+
+func Test_ClientCredentials_private_key_jwt(t *testing.T) {
+	var tokenURL string
+	endpoint := newFakeTokenEndpoint(func(r *http.Request) {
+		_, _, basic := r.BasicAuth()
+		assert.False(t, basic)
+		assert.Equal(t, clientAssertionType, r.FormValue("client_assertion_type"))
+		assertion, err := jwt.ParseSigned(r.FormValue("client_assertion"))
+		assert.NoError(t, err)
+		assert.Equal(t, "key-1", assertion.Headers[0].KeyID)
+		var claims jwt.Claims
+		assert.NoError(t, assertion.Claims(dummyECKey.Public(), &claims))
+		assert.NoError(t, claims.Validate(jwt.Expected{Issuer: "worker", Subject: "worker", Audience: jwt.Audience{tokenURL}, Time: time.Now()}))
+		assert.NotEmpty(t, claims.ID)
+	})
+	defer endpoint.server.Close()
+	tokenURL = endpoint.server.URL + "/realms/test/protocol/openid-connect/token"
+	source, err := NewClientCredentialsTokenSource(ClientCredentialsConfig{
+		KeycloakConfig: KeycloakConfig{Url: endpoint.server.URL, Realm: "test"},
+		ClientID:       "worker",
+		PrivateKey:     &jose.JSONWebKey{Key: dummyECKey, KeyID: "key-1"},
+	})
+	assert.NoError(t, err)
+
+	token, err := source.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "service-token-1", token.AccessToken)
+}
+
+func Test_ClientCredentials_renews_before_expiry(t *testing.T) {
+	endpoint := newFakeTokenEndpoint(func(r *http.Request) {})
+	defer endpoint.server.Close()
+	endpoint.expiresIn = 120
+	source, _ := NewClientCredentialsTokenSource(ClientCredentialsConfig{
+		KeycloakConfig: KeycloakConfig{Url: endpoint.server.URL, Realm: "test"},
+		ClientID:       "worker",
+		ClientSecret:   "secret",
+		RenewBefore:    5 * time.Minute,
+	})
+
+	token, err := source.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "service-token-1", token.AccessToken)
+
+	token, err = source.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "service-token-1", token.AccessToken, "the valid token is used while renewing")
+	assert.Eventually(t, func() bool {
+		token, _ = source.Token()
+		return token.AccessToken != "service-token-1"
+	}, time.Second, 20*time.Millisecond)
+}
+
+func Test_ClientCredentials_timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	defer func(timeout time.Duration) { TokenRequestTimeout = timeout }(TokenRequestTimeout)
+	TokenRequestTimeout = 50 * time.Millisecond
+	source, _ := NewClientCredentialsTokenSource(ClientCredentialsConfig{
+		KeycloakConfig: KeycloakConfig{Url: server.URL, Realm: "test"},
+		ClientID:       "worker",
+		ClientSecret:   "secret",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := source.TokenContext(ctx)
+	assert.ErrorIs(t, err, ErrClientCredentials)
+
+	_, err = source.Token()
+	assert.ErrorIs(t, err, ErrClientCredentials)
+}
+
+func Test_ClientCredentials_backoff(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	source, _ := NewClientCredentialsTokenSource(ClientCredentialsConfig{
+		KeycloakConfig: KeycloakConfig{Url: server.URL, Realm: "test"},
+		ClientID:       "worker",
+		ClientSecret:   "secret",
+	})
+
+	for i := 0; i < 5; i++ {
+		_, err := source.Token()
+		assert.ErrorIs(t, err, ErrClientCredentials)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	source.mu.Lock()
+	assert.True(t, source.retryAt.After(time.Now()))
+	source.retryAt = time.Now()
+	source.mu.Unlock()
+	_, err := source.Token()
+	assert.ErrorIs(t, err, ErrClientCredentials)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func Test_ClientCredentials_backoff_doubles(t *testing.T) {
+	assert.Equal(t, TokenRetryBackoff, tokenRetryBackoff(1))
+	assert.Equal(t, 4*TokenRetryBackoff, tokenRetryBackoff(3))
+	assert.Equal(t, TokenMaxRetryBackoff, tokenRetryBackoff(100))
+}
+
+func Test_ClientCredentials_mtls_client(t *testing.T) {
+	certificate := tls.Certificate{Certificate: [][]byte{selfSignedCertificate("worker").Raw}}
+	client, err := tlsClient(nil, certificate)
+	assert.NoError(t, err)
+	assert.Equal(t, []tls.Certificate{certificate}, client.Transport.(*http.Transport).TLSClientConfig.Certificates)
+	if defaultConfig := http.DefaultTransport.(*http.Transport).TLSClientConfig; defaultConfig != nil {
+		assert.Empty(t, defaultConfig.Certificates)
+	}
+
+	_, err = tlsClient(&http.Client{Transport: roundTripperFunc(nil)}, certificate)
+	assert.Error(t, err)
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,70 @@
+package ginkeycloak
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultCORSMaxAge is used for preflight responses if KeycloakConfig.CORSMaxAge is not set
+var DefaultCORSMaxAge = 20 * time.Minute
+
+// DefaultCORSAllowedMethods is used for preflight responses if KeycloakConfig.CORSAllowedMethods is empty
+var DefaultCORSAllowedMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// DefaultCORSAllowedHeaders is used for preflight responses if KeycloakConfig.CORSAllowedHeaders is empty
+var DefaultCORSAllowedHeaders = []string{"Authorization", "Content-Type"}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// handlePreflight answers CORS preflight requests without authentication like the Keycloak adapters do
+func handlePreflight(ctx *gin.Context, config KeycloakConfig) {
+	methods := config.CORSAllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultCORSAllowedMethods
+	}
+	headers := config.CORSAllowedHeaders
+	if len(headers) == 0 {
+		headers = DefaultCORSAllowedHeaders
+	}
+	maxAge := config.CORSMaxAge
+	if maxAge == 0 {
+		maxAge = DefaultCORSMaxAge
+	}
+
+	ctx.Header("Access-Control-Allow-Origin", ctx.Request.Header.Get("Origin"))
+	ctx.Header("Access-Control-Allow-Credentials", "true")
+	ctx.Header("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	ctx.Header("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	ctx.Header("Access-Control-Max-Age", strconv.Itoa(int(maxAge/time.Second)))
+	ctx.Header("Vary", "Origin")
+	ctx.AbortWithStatus(http.StatusNoContent)
+}
+
+// checkOrigin validates the Origin header against the allowed-origins of the token and sets the
+// CORS response headers. Requests without Origin header are same-origin or non-browser requests.
+func checkOrigin(tc *TokenContainer, ctx *gin.Context, config KeycloakConfig) bool {
+	origin := ctx.Request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	ctx.Header("Vary", "Origin")
+	for _, allowed := range tc.KeyCloakToken.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			ctx.Header("Access-Control-Allow-Origin", origin)
+			ctx.Header("Access-Control-Allow-Credentials", "true")
+			if len(config.CORSExposedHeaders) > 0 {
+				ctx.Header("Access-Control-Expose-Headers", strings.Join(config.CORSExposedHeaders, ", "))
+			}
+			return true
+		}
+	}
+	return false
+}
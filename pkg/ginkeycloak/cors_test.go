@@ -0,0 +1,67 @@
+package ginkeycloak
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func webToken() string {
+	token := createToken(time.Now().Add(time.Minute))
+	token.Azp = "frontend"
+	token.AllowedOrigins = []string{"https://app.example.com"}
+	return signRSAToken(token)
+}
+
+func Test_AuthorizedParty(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{AuthorizedParties: []string{"frontend"}})
+	ctx := buildContext(webToken())
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+
+	authFunc = Auth(AuthCheck(), KeycloakConfig{AuthorizedParties: []string{"backend"}})
+	ctx = buildContext(webToken())
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.Equal(t, ErrUnauthorizedParty, ctx.Errors[0].Err)
+	assert.Equal(t, http.StatusForbidden, ctx.Writer.Status())
+}
+
+func Test_CORS_allowed_origin(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{EnableCORS: true})
+	ctx := buildContext(webToken())
+	ctx.Request.Header.Set("Origin", "https://app.example.com")
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 0)
+	assert.Equal(t, "https://app.example.com", ctx.Writer.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", ctx.Writer.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func Test_CORS_disallowed_origin(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{EnableCORS: true})
+	ctx := buildContext(webToken())
+	ctx.Request.Header.Set("Origin", "https://evil.example.com")
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.Equal(t, ErrOriginNotAllowed, ctx.Errors[0].Err)
+	assert.Empty(t, ctx.Writer.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func Test_CORS_preflight(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{EnableCORS: true})
+	ctx := buildContext("")
+	ctx.Request.Method = http.MethodOptions
+	ctx.Request.Header.Del("Authorization")
+	ctx.Request.Header.Set("Origin", "https://app.example.com")
+	ctx.Request.Header.Set("Access-Control-Request-Method", "POST")
+	authFunc(ctx)
+
+	assert.True(t, ctx.IsAborted())
+	assert.True(t, len(ctx.Errors) == 0)
+	assert.Equal(t, http.StatusNoContent, ctx.Writer.Status())
+	assert.Equal(t, "1200", ctx.Writer.Header().Get("Access-Control-Max-Age"))
+}
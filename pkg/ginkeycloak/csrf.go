@@ -0,0 +1,249 @@
+package ginkeycloak
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+)
+
+// CSRFTokenKey is the gin context key of the CSRF token of the request, e.g. for forms in templates
+const CSRFTokenKey = "csrfToken"
+
+var ErrCSRF = errors.New("CSRF check failed")
+
+// CSRFConfig configures the CSRF protection of cookie authenticated requests
+type CSRFConfig struct {
+	// Secret signs the tokens, required
+	Secret []byte
+	// SessionID binds tokens to the session (synchronizer token pattern), e.g. Login.SessionID.
+	// If nil, a signed random token is kept in a cookie (double-submit cookie pattern).
+	SessionID func(ctx *gin.Context) (string, bool)
+	// CookieName is readable by JavaScript so it can be echoed in HeaderName, defaults to XSRF-TOKEN
+	CookieName string
+	// HeaderName defaults to X-XSRF-TOKEN
+	HeaderName string
+	// FormField is checked for form posts if the header is missing, defaults to _csrf
+	FormField    string
+	CookiePath   string
+	CookieDomain string
+	// InsecureCookie allows the cookie to be sent over http, only for local development
+	InsecureCookie bool
+	// TrustedOrigins may send unsafe requests in addition to the origin of the service itself
+	TrustedOrigins []string
+	// ExternalURL is the scheme and host browsers use to reach the service, e.g. behind a TLS
+	// terminating proxy. Defaults to the scheme and host of the request.
+	ExternalURL string
+}
+
+// CSRFProtection issues CSRF tokens on safe requests and verifies them on unsafe requests
+type CSRFProtection struct {
+	config CSRFConfig
+}
+
+func NewCSRFProtection(config CSRFConfig) (*CSRFProtection, error) {
+	if len(config.Secret) < 16 {
+		return nil, errors.New("CSRF secret must have at least 16 bytes")
+	}
+	if config.CookieName == "" {
+		config.CookieName = "XSRF-TOKEN"
+	}
+	if config.HeaderName == "" {
+		config.HeaderName = "X-XSRF-TOKEN"
+	}
+	if config.FormField == "" {
+		config.FormField = "_csrf"
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = "/"
+	}
+	return &CSRFProtection{config: config}, nil
+}
+
+// Middleware protects all requests except those with a Bearer or DPoP Authorization header,
+// which browsers never add on their own. Basic credentials are sent by browsers automatically,
+// so these requests are protected too. Without SessionID the tokens are bound to the subject
+// of the token set by a preceding Auth middleware.
+func (p *CSRFProtection) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, err := extractToken(ctx.Request); err == nil {
+			return
+		}
+		subject := ""
+		if token, ok := ctx.Get("token"); ok {
+			if keyCloakToken, ok := token.(KeyCloakToken); ok {
+				subject = keyCloakToken.Sub
+			}
+		}
+		if err := p.protect(ctx, subject); err != nil {
+			glog.Errorf("[Gin-OAuth] %s", err)
+			_ = ctx.AbortWithError(http.StatusForbidden, err)
+		}
+	}
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// protect issues a token on safe requests and verifies origin and token on unsafe requests,
+// double-submit tokens are bound to the subject of the authenticated token
+func (p *CSRFProtection) protect(ctx *gin.Context, subject string) error {
+	if isSafeMethod(ctx.Request.Method) {
+		token, err := p.issue(ctx, subject)
+		if err != nil {
+			return err
+		}
+		ctx.Set(CSRFTokenKey, token)
+		return nil
+	}
+	if err := p.checkOrigin(ctx.Request); err != nil {
+		return err
+	}
+	return p.checkToken(ctx, subject)
+}
+
+func (p *CSRFProtection) sign(value string) string {
+	mac := hmac.New(sha256.New, p.config.Secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issue returns the token of the request and sets the cookie if needed
+func (p *CSRFProtection) issue(ctx *gin.Context, subject string) (string, error) {
+	var token string
+	if p.config.SessionID != nil {
+		sessionID, ok := p.config.SessionID(ctx)
+		if !ok {
+			return "", nil
+		}
+		token = p.sign("session:" + sessionID)
+	} else {
+		if cookie, err := ctx.Request.Cookie(p.config.CookieName); err == nil && p.validDoubleSubmitToken(cookie.Value, subject) {
+			return cookie.Value, nil
+		}
+		random, err := randomString()
+		if err != nil {
+			return "", err
+		}
+		token = random + "." + p.sign("random:"+random+"|sub:"+subject)
+	}
+
+	if cookie, err := ctx.Request.Cookie(p.config.CookieName); err != nil || cookie.Value != token {
+		http.SetCookie(ctx.Writer, &http.Cookie{
+			Name:     p.config.CookieName,
+			Value:    token,
+			Path:     p.config.CookiePath,
+			Domain:   p.config.CookieDomain,
+			Secure:   !p.config.InsecureCookie,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	return token, nil
+}
+
+func (p *CSRFProtection) validDoubleSubmitToken(token string, subject string) bool {
+	parts := strings.SplitN(token, ".", 2)
+	return len(parts) == 2 && hmac.Equal([]byte(parts[1]), []byte(p.sign("random:"+parts[0]+"|sub:"+subject)))
+}
+
+func (p *CSRFProtection) checkToken(ctx *gin.Context, subject string) error {
+	submitted := ctx.Request.Header.Get(p.config.HeaderName)
+	if submitted == "" {
+		submitted = ctx.PostForm(p.config.FormField)
+	}
+	if submitted == "" {
+		return fmt.Errorf("%w: no token submitted", ErrCSRF)
+	}
+
+	var expected string
+	if p.config.SessionID != nil {
+		sessionID, ok := p.config.SessionID(ctx)
+		if !ok {
+			return fmt.Errorf("%w: no session", ErrCSRF)
+		}
+		expected = p.sign("session:" + sessionID)
+	} else {
+		cookie, err := ctx.Request.Cookie(p.config.CookieName)
+		if err != nil || !p.validDoubleSubmitToken(cookie.Value, subject) {
+			return fmt.Errorf("%w: no valid token cookie", ErrCSRF)
+		}
+		expected = cookie.Value
+	}
+	if subtle.ConstantTimeCompare([]byte(submitted), []byte(expected)) != 1 {
+		return fmt.Errorf("%w: token mismatch", ErrCSRF)
+	}
+	return nil
+}
+
+// checkOrigin rejects unsafe requests from other origins, using Referer if Origin is missing
+func (p *CSRFProtection) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		referer, err := url.Parse(r.Header.Get("Referer"))
+		if err != nil || referer.Host == "" {
+			if origin == "null" {
+				return fmt.Errorf("%w: opaque origin", ErrCSRF)
+			}
+			return nil
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+
+	originURL, err := url.Parse(origin)
+	if err != nil || originURL.Host == "" {
+		return fmt.Errorf("%w: invalid origin %s", ErrCSRF, origin)
+	}
+	if sameOrigin(originURL, p.serviceOrigin(r)) {
+		return nil
+	}
+	for _, trusted := range p.config.TrustedOrigins {
+		if trustedURL, err := url.Parse(trusted); err == nil && sameOrigin(originURL, trustedURL) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: origin %s not allowed", ErrCSRF, origin)
+}
+
+// serviceOrigin is the origin browsers use to reach the service
+func (p *CSRFProtection) serviceOrigin(r *http.Request) *url.URL {
+	if p.config.ExternalURL != "" {
+		if external, err := url.Parse(p.config.ExternalURL); err == nil {
+			return external
+		}
+	}
+	scheme := "http"
+	if r.TLS != nil || r.URL.Scheme == "https" {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: r.Host}
+}
+
+// sameOrigin compares scheme, host and port, default ports may be omitted
+func sameOrigin(a *url.URL, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		strings.EqualFold(a.Hostname(), b.Hostname()) &&
+		originPort(a) == originPort(b)
+}
+
+func originPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if strings.EqualFold(u.Scheme, "https") {
+		return "443"
+	}
+	return "80"
+}
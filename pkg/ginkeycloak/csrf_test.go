@@ -0,0 +1,197 @@
+package ginkeycloak
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCSRF(t *testing.T, sessionID func(ctx *gin.Context) (string, bool)) *CSRFProtection {
+	csrf, err := NewCSRFProtection(CSRFConfig{Secret: []byte("0123456789abcdef"), SessionID: sessionID})
+	assert.NoError(t, err)
+	return csrf
+}
+
+func buildCookieContext(method string, token string) *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request, _ = http.NewRequest(method, "https://app.example.com/test", nil)
+	ctx.Request.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+	return ctx
+}
+
+func Test_TokenCookie(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{TokenCookieName: "access_token"})
+	ctx := buildCookieContext(http.MethodGet, signRSAToken(createToken(time.Now().Add(time.Minute))))
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+}
+
+func Test_CSRF_double_submit(t *testing.T) {
+	csrf := newTestCSRF(t, nil)
+	config := KeycloakConfig{TokenCookieName: "access_token", CSRF: csrf}
+	token := signRSAToken(createToken(time.Now().Add(time.Minute)))
+
+	ctx := buildCookieContext(http.MethodGet, token)
+	Auth(AuthCheck(), config)(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+	cookie := (&http.Response{Header: ctx.Writer.Header()}).Cookies()[0]
+	assert.Equal(t, "XSRF-TOKEN", cookie.Name)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+	assert.Equal(t, cookie.Value, ctx.GetString(CSRFTokenKey))
+
+	ctx = buildCookieContext(http.MethodPost, token)
+	ctx.Request.AddCookie(cookie)
+	ctx.Request.Header.Set("X-XSRF-TOKEN", cookie.Value)
+	ctx.Request.Header.Set("Origin", "https://app.example.com")
+	Auth(AuthCheck(), config)(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+
+	ctx = buildCookieContext(http.MethodPost, token)
+	ctx.Request.AddCookie(cookie)
+	Auth(AuthCheck(), config)(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.ErrorIs(t, ctx.Errors[0].Err, ErrCSRF)
+	assert.Equal(t, http.StatusForbidden, ctx.Writer.Status())
+}
+
+func Test_CSRF_rejects_forged_cookie(t *testing.T) {
+	csrf := newTestCSRF(t, nil)
+	ctx := buildCookieContext(http.MethodPost, "")
+	ctx.Request.AddCookie(&http.Cookie{Name: "XSRF-TOKEN", Value: "forged.value"})
+	ctx.Request.Header.Set("X-XSRF-TOKEN", "forged.value")
+	assert.ErrorIs(t, csrf.protect(ctx, ""), ErrCSRF)
+}
+
+func Test_CSRF_double_submit_bound_to_subject(t *testing.T) {
+	csrf := newTestCSRF(t, nil)
+	ctx := buildCookieContext(http.MethodGet, "")
+	assert.NoError(t, csrf.protect(ctx, "alice"))
+	token := ctx.GetString(CSRFTokenKey)
+
+	for subject, valid := range map[string]bool{"alice": true, "mallory": false} {
+		ctx = buildCookieContext(http.MethodPost, "")
+		ctx.Request.AddCookie(&http.Cookie{Name: "XSRF-TOKEN", Value: token})
+		ctx.Request.Header.Set("X-XSRF-TOKEN", token)
+		assert.Equal(t, valid, csrf.protect(ctx, subject) == nil, subject)
+	}
+}
+
+func Test_CSRF_session_bound(t *testing.T) {
+	sessionID := "session-1"
+	csrf := newTestCSRF(t, func(ctx *gin.Context) (string, bool) { return sessionID, true })
+
+	ctx := buildCookieContext(http.MethodGet, "")
+	assert.NoError(t, csrf.protect(ctx, ""))
+	token := ctx.GetString(CSRFTokenKey)
+	assert.NotEmpty(t, token)
+
+	ctx = buildCookieContext(http.MethodPost, "")
+	ctx.Request.Header.Set("X-XSRF-TOKEN", token)
+	assert.NoError(t, csrf.protect(ctx, ""))
+
+	sessionID = "session-2"
+	ctx = buildCookieContext(http.MethodPost, "")
+	ctx.Request.Header.Set("X-XSRF-TOKEN", token)
+	assert.ErrorIs(t, csrf.protect(ctx, ""), ErrCSRF)
+}
+
+func Test_CSRF_origin(t *testing.T) {
+	csrf, _ := NewCSRFProtection(CSRFConfig{
+		Secret:         []byte("0123456789abcdef"),
+		SessionID:      func(ctx *gin.Context) (string, bool) { return "session", true },
+		TrustedOrigins: []string{"https://admin.example.com"},
+	})
+	token := csrf.sign("session:session")
+
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com":      true,
+		"https://app.example.com:443":  true,
+		"https://admin.example.com":    true,
+		"http://app.example.com":       false,
+		"https://app.example.com:8443": false,
+		"http://admin.example.com":     false,
+		"https://evil.example.com":     false,
+		"null":                         false,
+	} {
+		ctx := buildCookieContext(http.MethodPost, "")
+		ctx.Request.Header.Set("X-XSRF-TOKEN", token)
+		ctx.Request.Header.Set("Origin", origin)
+		assert.Equal(t, allowed, csrf.protect(ctx, "") == nil, origin)
+	}
+
+	ctx := buildCookieContext(http.MethodPost, "")
+	ctx.Request.Header.Set("X-XSRF-TOKEN", token)
+	ctx.Request.Header.Set("Referer", "https://evil.example.com/form")
+	assert.ErrorIs(t, csrf.protect(ctx, ""), ErrCSRF)
+}
+
+func Test_CSRF_external_url(t *testing.T) {
+	csrf, _ := NewCSRFProtection(CSRFConfig{
+		Secret:      []byte("0123456789abcdef"),
+		SessionID:   func(ctx *gin.Context) (string, bool) { return "session", true },
+		ExternalURL: "https://app.example.com",
+	})
+	ctx := buildCookieContext(http.MethodPost, "")
+	ctx.Request.URL.Scheme = "http"
+	ctx.Request.Header.Set("X-XSRF-TOKEN", csrf.sign("session:session"))
+	ctx.Request.Header.Set("Origin", "https://app.example.com")
+	assert.NoError(t, csrf.protect(ctx, ""))
+}
+
+func Test_CSRF_exempts_bearer_header(t *testing.T) {
+	csrf := newTestCSRF(t, nil)
+	config := KeycloakConfig{TokenCookieName: "access_token", CSRF: csrf}
+	ctx := buildContext(signRSAToken(createToken(time.Now().Add(time.Minute))))
+	ctx.Request.Method = http.MethodPost
+	Auth(AuthCheck(), config)(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+
+	ctx = buildContext("")
+	ctx.Request.Method = http.MethodPost
+	csrf.Middleware()(ctx)
+	assert.False(t, ctx.IsAborted())
+}
+
+func Test_CSRF_checks_basic_auth(t *testing.T) {
+	csrf := newTestCSRF(t, nil)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request, _ = http.NewRequest(http.MethodPost, "https://app.example.com/test", nil)
+	ctx.Request.SetBasicAuth("user", "password")
+
+	csrf.Middleware()(ctx)
+
+	assert.True(t, ctx.IsAborted())
+	assert.Equal(t, http.StatusForbidden, ctx.Writer.Status())
+}
+
+func Test_CSRF_login_session(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	login, router := newTestLogin(t, kc)
+	_, err := login.CSRFProtection(CSRFConfig{Secret: []byte("0123456789abcdef")})
+	assert.NoError(t, err)
+	router.POST("/private", login.Auth(AuthCheck()), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	sessionCookie := loginFlow(t, kc, router)
+	resp := serve(router, "/private", []*http.Cookie{sessionCookie})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	csrfCookie := responseCookie(resp, "XSRF-TOKEN")
+	assert.NotNil(t, csrfCookie)
+
+	post := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/private", nil)
+		req.AddCookie(sessionCookie)
+		req.Header.Set("X-XSRF-TOKEN", token)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	assert.Equal(t, http.StatusOK, post(csrfCookie.Value))
+	assert.Equal(t, http.StatusForbidden, post("forged"))
+}
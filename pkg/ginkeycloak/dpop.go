@@ -0,0 +1,162 @@
+package ginkeycloak
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/oauth2"
+	"gopkg.in/go-jose/go-jose.v2"
+)
+
+// DefaultDPoPProofMaxAge is used if KeycloakConfig.DPoPProofMaxAge is not set
+var DefaultDPoPProofMaxAge = 60 * time.Second
+
+// DPoPClockSkew is the tolerated clock difference between client and server for the iat of a proof
+var DPoPClockSkew = 5 * time.Second
+
+var dpopJtiCache = cache.New(5*time.Minute, 10*time.Minute)
+
+var ErrInvalidDPoPProof = errors.New("Invalid DPoP proof")
+
+const dpopScheme = "DPoP"
+
+type dpopProofClaims struct {
+	Jti string `json:"jti"`
+	Htm string `json:"htm"`
+	Htu string `json:"htu"`
+	Iat int64  `json:"iat"`
+	Ath string `json:"ath"`
+}
+
+func dpopError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidDPoPProof}, args...)...)
+}
+
+// validateDPoP checks the sender constraint of DPoP bound tokens (RFC 9449)
+func validateDPoP(r *http.Request, token *oauth2.Token, keyCloakToken *KeyCloakToken, config KeycloakConfig) error {
+	isDPoPScheme := strings.EqualFold(token.TokenType, dpopScheme)
+	jkt := ""
+	if keyCloakToken.Cnf != nil {
+		jkt = keyCloakToken.Cnf.Jkt
+	}
+
+	if jkt == "" {
+		if isDPoPScheme {
+			return dpopError("token is not DPoP bound")
+		}
+		if config.RequireDPoP {
+			return dpopError("DPoP bound token required")
+		}
+		return nil
+	}
+	if !isDPoPScheme {
+		return dpopError("DPoP bound token used with %s scheme", token.TokenType)
+	}
+
+	proofs := r.Header.Values(dpopScheme)
+	if len(proofs) != 1 {
+		return dpopError("expected exactly one DPoP header, got %d", len(proofs))
+	}
+	jws, err := jose.ParseSigned(proofs[0])
+	if err != nil {
+		return dpopError("proof not decodable: %s", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return dpopError("proof must have exactly one signature")
+	}
+	header := jws.Signatures[0].Header
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != "dpop+jwt" {
+		return dpopError("invalid typ header %q", typ)
+	}
+	if header.JSONWebKey == nil || !header.JSONWebKey.IsPublic() {
+		return dpopError("proof must contain a public jwk")
+	}
+	payload, err := jws.Verify(header.JSONWebKey)
+	if err != nil {
+		return dpopError("proof signature invalid: %s", err)
+	}
+
+	var claims dpopProofClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return dpopError("proof claims not decodable: %s", err)
+	}
+	if claims.Jti == "" {
+		return dpopError("proof has no jti")
+	}
+	if claims.Htm != r.Method {
+		return dpopError("htm %q does not match request method %q", claims.Htm, r.Method)
+	}
+	if !htuMatches(claims.Htu, requestURL(r, config)) {
+		return dpopError("htu %q does not match request", claims.Htu)
+	}
+
+	maxAge := config.DPoPProofMaxAge
+	if maxAge == 0 {
+		maxAge = DefaultDPoPProofMaxAge
+	}
+	iat := time.Unix(claims.Iat, 0)
+	now := time.Now()
+	if iat.Before(now.Add(-maxAge-DPoPClockSkew)) || iat.After(now.Add(DPoPClockSkew)) {
+		return dpopError("proof iat outside of the accepted window")
+	}
+
+	ath := sha256.Sum256([]byte(token.AccessToken))
+	if claims.Ath != base64.RawURLEncoding.EncodeToString(ath[:]) {
+		return dpopError("ath does not match access token")
+	}
+
+	thumbprint, err := header.JSONWebKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return dpopError("can not compute jwk thumbprint: %s", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(thumbprint)), []byte(jkt)) != 1 {
+		return dpopError("proof key does not match cnf.jkt")
+	}
+
+	// jti values are only unique per client key
+	if err = dpopJtiCache.Add(jkt+"|"+claims.Jti, true, maxAge+2*DPoPClockSkew); err != nil {
+		return dpopError("proof jti has already been used")
+	}
+	return nil
+}
+
+// requestURL reconstructs the URL the client used, KeycloakConfig.DPoPExternalURL
+// overrides scheme and host behind reverse proxies
+func requestURL(r *http.Request, config KeycloakConfig) string {
+	if config.DPoPExternalURL != "" {
+		return strings.TrimSuffix(config.DPoPExternalURL, "/") + r.URL.EscapedPath()
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
+
+func htuMatches(htu string, expected string) bool {
+	a, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(expected)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		strings.EqualFold(a.Host, b.Host) &&
+		a.EscapedPath() == b.EscapedPath()
+}
+
+func dpopChallenge() string {
+	return `DPoP error="invalid_dpop_proof", algs="ES256 ES384 ES512 RS256 RS384 RS512 PS256 PS384 PS512"`
+}
@@ -0,0 +1,131 @@
+package ginkeycloak
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/go-jose/go-jose.v2"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+)
+
+var dpopKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+func dpopBoundToken() string {
+	jwk := jose.JSONWebKey{Key: dpopKey.Public()}
+	thumbprint, _ := jwk.Thumbprint(crypto.SHA256)
+	token := createToken(time.Now().Add(time.Minute))
+	token.Cnf = &Confirmation{Jkt: base64.RawURLEncoding.EncodeToString(thumbprint)}
+	return signRSAToken(token)
+}
+
+func dpopProof(accessToken string, method string, htu string, jti string) string {
+	signer, _ := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: dpopKey},
+		(&jose.SignerOptions{EmbedJWK: true}).WithType("dpop+jwt"))
+	ath := sha256.Sum256([]byte(accessToken))
+	proof, _ := jwt.Signed(signer).Claims(dpopProofClaims{
+		Jti: jti,
+		Htm: method,
+		Htu: htu,
+		Iat: time.Now().Unix(),
+		Ath: base64.RawURLEncoding.EncodeToString(ath[:]),
+	}).CompactSerialize()
+	return proof
+}
+
+func buildDPoPContext(token string, proof string) *gin.Context {
+	ctx := buildContext(token)
+	ctx.Request.Host = "api.example.com"
+	ctx.Request.Header.Set("Authorization", "DPoP "+token)
+	ctx.Request.Header.Set("DPoP", proof)
+	return ctx
+}
+
+func Test_DPoP_valid_proof(t *testing.T) {
+	token := dpopBoundToken()
+	authFunc := Auth(AuthCheck(), KeycloakConfig{})
+
+	ctx := buildDPoPContext(token, dpopProof(token, http.MethodGet, "http://api.example.com/test", "valid-proof"))
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 0)
+}
+
+func Test_DPoP_replayed_proof(t *testing.T) {
+	token := dpopBoundToken()
+	authFunc := Auth(AuthCheck(), KeycloakConfig{})
+	proof := dpopProof(token, http.MethodGet, "http://api.example.com/test", "replayed-proof")
+
+	ctx := buildDPoPContext(token, proof)
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+
+	ctx = buildDPoPContext(token, proof)
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrInvalidDPoPProof))
+	assert.Contains(t, ctx.Writer.Header().Get("WWW-Authenticate"), `error="invalid_dpop_proof"`)
+}
+
+func Test_DPoP_wrong_htu(t *testing.T) {
+	token := dpopBoundToken()
+	authFunc := Auth(AuthCheck(), KeycloakConfig{})
+
+	ctx := buildDPoPContext(token, dpopProof(token, http.MethodGet, "http://other.example.com/test", "wrong-htu"))
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrInvalidDPoPProof))
+}
+
+func Test_DPoP_bound_token_as_bearer(t *testing.T) {
+	token := dpopBoundToken()
+	authFunc := Auth(AuthCheck(), KeycloakConfig{})
+
+	ctx := buildContext(token)
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrInvalidDPoPProof))
+}
+
+func Test_DPoP_required(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{RequireDPoP: true})
+
+	ctx := buildContext(tokens[0])
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrInvalidDPoPProof))
+}
+
+func Test_DPoP_lowercase_scheme(t *testing.T) {
+	token := dpopBoundToken()
+	authFunc := Auth(AuthCheck(), KeycloakConfig{})
+
+	ctx := buildDPoPContext(token, dpopProof(token, http.MethodGet, "http://api.example.com/test", "lowercase-scheme"))
+	ctx.Request.Header.Set("Authorization", "dpop "+token)
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 0)
+}
+
+func Test_DPoP_jti_replay_is_per_key(t *testing.T) {
+	token := dpopBoundToken()
+	authFunc := Auth(AuthCheck(), KeycloakConfig{})
+	assert.NoError(t, dpopJtiCache.Add("other-jkt|shared-jti", true, time.Minute))
+
+	ctx := buildDPoPContext(token, dpopProof(token, http.MethodGet, "http://api.example.com/test", "shared-jti"))
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 0)
+}
@@ -0,0 +1,122 @@
+package ginkeycloak
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// TokenExchangeCacheSize limits the number of exchanged tokens kept by a TokenExchanger
+var TokenExchangeCacheSize = 10000
+
+// TokenExchangeExpiryMargin is how long before their expiry exchanged tokens are no longer reused
+var TokenExchangeExpiryMargin = 10 * time.Second
+
+var ErrTokenExchange = errors.New("Token exchange failed")
+
+// TokenExchangeConfig configures the client exchanging tokens, it needs the token exchange
+// permission for the target audiences in Keycloak
+type TokenExchangeConfig struct {
+	KeycloakConfig KeycloakConfig
+	ClientID       string
+	ClientSecret   string
+}
+
+// TokenExchanger exchanges the tokens of incoming requests for tokens of downstream services
+// (RFC 8693)
+type TokenExchanger struct {
+	config   TokenExchangeConfig
+	tokenURL string
+	cache    *lruCache
+}
+
+func NewTokenExchanger(config TokenExchangeConfig) (*TokenExchanger, error) {
+	tokenURL, err := realmURL(config.KeycloakConfig, "protocol/openid-connect/token")
+	if err != nil {
+		return nil, err
+	}
+	return &TokenExchanger{config: config, tokenURL: tokenURL, cache: newLRUCache(TokenExchangeCacheSize)}, nil
+}
+
+// Exchange returns a token for the audience and scopes on behalf of the subject of the token
+// container, exchanged tokens are reused until shortly before they expire
+func (e *TokenExchanger) Exchange(ctx context.Context, tokenContainer *TokenContainer, audience string, scopes ...string) (*oauth2.Token, error) {
+	if tokenContainer == nil || tokenContainer.Token == nil {
+		return nil, ErrNoToken
+	}
+	subjectToken := tokenContainer.Token.AccessToken
+	scope := strings.Join(scopes, " ")
+	sum := sha256.Sum256([]byte(subjectToken))
+	key := hex.EncodeToString(sum[:]) + "|" + audience + "|" + scope
+	if cached, ok := e.cache.get(key); ok {
+		token := *cached.(*oauth2.Token)
+		return &token, nil
+	}
+
+	params := url.Values{
+		"grant_type":           {tokenExchangeGrantType},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {accessTokenType},
+		"requested_token_type": {accessTokenType},
+		"audience":             {audience},
+	}
+	if scope != "" {
+		params.Set("scope", scope)
+	}
+	token, err := postTokenRequest(ctx, e.config.KeycloakConfig.HTTPClient, e.tokenURL, params, e.config.ClientID, e.config.ClientSecret)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrTokenExchange, err)
+	}
+	if !token.Expiry.IsZero() {
+		cached := *token
+		e.cache.set(key, &cached, token.Expiry.Add(-TokenExchangeExpiryMargin))
+	}
+	return token, nil
+}
+
+type tokenExchangeTransport struct {
+	exchanger *TokenExchanger
+	audience  string
+	scopes    []string
+	base      http.RoundTripper
+}
+
+// Transport adds a token for the audience, exchanged from the token found in the request context,
+// to outgoing requests. Requests without token in their context are sent unchanged. Base defaults
+// to http.DefaultTransport.
+func (e *TokenExchanger) Transport(audience string, base http.RoundTripper, scopes ...string) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tokenExchangeTransport{exchanger: e, audience: audience, scopes: scopes, base: base}
+}
+
+func (t *tokenExchangeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tokenContainer, ok := TokenContainerFromContext(req.Context())
+	if !ok || req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	token, err := t.exchanger.Exchange(req.Context(), tokenContainer, t.audience, t.scopes...)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	exchanged := req.Clone(req.Context())
+	exchanged.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return t.base.RoundTrip(exchanged)
+}
@@ -0,0 +1,94 @@
+package ginkeycloak
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeTokenExchange(t *testing.T, calls *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		assert.Equal(t, "/realms/test/protocol/openid-connect/token", r.URL.Path)
+		clientID, clientSecret, _ := r.BasicAuth()
+		assert.Equal(t, "gateway", clientID)
+		assert.Equal(t, "secret", clientSecret)
+		assert.Equal(t, tokenExchangeGrantType, r.FormValue("grant_type"))
+		assert.Equal(t, accessTokenType, r.FormValue("subject_token_type"))
+		if r.FormValue("audience") != "orders" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client", "error_description": "Client not allowed to exchange"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":      "exchanged-" + r.FormValue("scope"),
+			"token_type":        "Bearer",
+			"expires_in":        300,
+			"issued_token_type": accessTokenType,
+		})
+	}))
+}
+
+func Test_TokenExchanger(t *testing.T) {
+	calls := 0
+	server := newFakeTokenExchange(t, &calls)
+	defer server.Close()
+	exchanger, err := NewTokenExchanger(TokenExchangeConfig{
+		KeycloakConfig: KeycloakConfig{Url: server.URL, Realm: "test"},
+		ClientID:       "gateway",
+		ClientSecret:   "secret",
+	})
+	assert.NoError(t, err)
+	tokenContainer := &TokenContainer{Token: tokenFromString("subject")}
+
+	token, err := exchanger.Exchange(context.Background(), tokenContainer, "orders", "read")
+	assert.NoError(t, err)
+	assert.Equal(t, "exchanged-read", token.AccessToken)
+	assert.WithinDuration(t, time.Now().Add(300*time.Second), token.Expiry, 5*time.Second)
+
+	token, err = exchanger.Exchange(context.Background(), tokenContainer, "orders", "read")
+	assert.NoError(t, err)
+	assert.Equal(t, "exchanged-read", token.AccessToken)
+	assert.Equal(t, 1, calls)
+
+	_, err = exchanger.Exchange(context.Background(), tokenContainer, "orders", "write")
+	assert.NoError(t, err)
+	_, err = exchanger.Exchange(context.Background(), &TokenContainer{Token: tokenFromString("other")}, "orders", "read")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	_, err = exchanger.Exchange(context.Background(), tokenContainer, "billing")
+	assert.ErrorIs(t, err, ErrTokenExchange)
+	assert.Contains(t, err.Error(), "Client not allowed to exchange")
+}
+
+func Test_TokenExchanger_transport(t *testing.T) {
+	calls := 0
+	server := newFakeTokenExchange(t, &calls)
+	defer server.Close()
+	var authorization string
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer downstream.Close()
+
+	exchanger, _ := NewTokenExchanger(TokenExchangeConfig{
+		KeycloakConfig: KeycloakConfig{Url: server.URL, Realm: "test"},
+		ClientID:       "gateway",
+		ClientSecret:   "secret",
+	})
+	ctx := buildContext(signRSAToken(createToken(time.Now().Add(time.Minute))))
+	Auth(AuthCheck(), KeycloakConfig{})(ctx)
+
+	client := &http.Client{Transport: exchanger.Transport("orders", nil)}
+	req, _ := http.NewRequestWithContext(ctx.Request.Context(), http.MethodGet, downstream.URL, nil)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "Bearer exchanged-", authorization)
+}
@@ -5,9 +5,8 @@ import (
 	"crypto/elliptic"
 	"crypto/rsa"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"fmt"
 	"math/big"
 	"net/http"
 	"net/url"
@@ -19,6 +18,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/patrickmn/go-cache"
 	"golang.org/x/oauth2"
+	"gopkg.in/go-jose/go-jose.v2"
 	"gopkg.in/go-jose/go-jose.v2/jwt"
 )
 
@@ -26,10 +26,21 @@ import (
 var VarianceTimer = 30000 * time.Millisecond
 var publicKeyCache = cache.New(8*time.Hour, 8*time.Hour)
 
+// DefaultAllowedTokenTypes are the typ claims accepted if KeycloakConfig.AllowedTokenTypes is empty
+var DefaultAllowedTokenTypes = []string{"Bearer"}
+
+var (
+	ErrNoToken           = errors.New("No token in context")
+	ErrInvalidTokenType  = errors.New("Invalid token type")
+	ErrUnauthorizedParty = errors.New("Token was issued to a client that is not allowed")
+	ErrOriginNotAllowed  = errors.New("Origin is not allowed by the token")
+)
+
 // TokenContainer stores all relevant token information
 type TokenContainer struct {
 	Token         *oauth2.Token
 	KeyCloakToken *KeyCloakToken
+	RoleHierarchy *RoleHierarchy
 }
 
 func extractToken(r *http.Request) (*oauth2.Token, error) {
@@ -42,15 +53,60 @@ func extractToken(r *http.Request) (*oauth2.Token, error) {
 	if len(th) != 2 {
 		return nil, errors.New("Incomplete authorization header")
 	}
+	// auth schemes are case-insensitive (RFC 7235), the token type is normalized
+	scheme := th[0]
+	switch {
+	case strings.EqualFold(scheme, "Bearer"):
+		scheme = "Bearer"
+	case strings.EqualFold(scheme, dpopScheme):
+		scheme = dpopScheme
+	default:
+		return nil, errors.New("Unsupported authorization scheme " + th[0])
+	}
 
-	return &oauth2.Token{AccessToken: th[1], TokenType: th[0]}, nil
+	return &oauth2.Token{AccessToken: th[1], TokenType: scheme}, nil
 }
 
+func extractCookieToken(r *http.Request, name string) (*oauth2.Token, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return nil, errors.New("No authorization header or token cookie")
+	}
+	return &oauth2.Token{AccessToken: cookie.Value, TokenType: "Bearer"}, nil
+}
+
+// GetTokenContainer verifies the token, tokens of TrustedIssuers are verified with the config of their issuer
 func GetTokenContainer(token *oauth2.Token, config KeycloakConfig) (*TokenContainer, error) {
+	tc, _, err := resolveTokenContainer(token, config)
+	return tc, err
+}
 
-	keyCloakToken, err := decodeToken(token, config)
+// resolveTokenContainer selects the config of the token issuer once and verifies the token with it,
+// the issuer config is returned for the checks that follow
+func resolveTokenContainer(token *oauth2.Token, config KeycloakConfig) (*TokenContainer, KeycloakConfig, error) {
+	issuerConfig, err := trustedIssuerConfig(token.AccessToken, config)
 	if err != nil {
-		return nil, err
+		return nil, config, err
+	}
+
+	var keyCloakToken *KeyCloakToken
+	var headerType string
+	cached := false
+	tokenCache := middlewareTokenCache(issuerConfig)
+	if tokenCache != nil {
+		keyCloakToken, headerType, cached = tokenCache.get(token.AccessToken, issuerConfig)
+	}
+	if !cached {
+		if keyCloakToken, headerType, err = verifyTokenSignature(token, issuerConfig); err != nil {
+			return nil, issuerConfig, err
+		}
+		if tokenCache != nil {
+			tokenCache.set(token.AccessToken, issuerConfig, keyCloakToken, headerType)
+		}
+	}
+	if err = checkTokenType(headerType, keyCloakToken, issuerConfig); err != nil {
+		glog.Errorf("[Gin-OAuth] %s", err)
+		return nil, issuerConfig, err
 	}
 
 	return &TokenContainer{
@@ -59,12 +115,13 @@ func GetTokenContainer(token *oauth2.Token, config KeycloakConfig) (*TokenContai
 			TokenType:   token.TokenType,
 		},
 		KeyCloakToken: keyCloakToken,
-	}, nil
+		RoleHierarchy: issuerConfig.RoleHierarchy,
+	}, issuerConfig, nil
 }
 
 func getPublicKey(keyId string, config KeycloakConfig) (interface{}, error) {
 
-	keyEntry, err := getPublicKeyFromCacheOrBackend(keyId, config)
+	keyEntry, err := keySource(config).GetKey(keyId)
 	if err != nil {
 		return nil, err
 	}
@@ -109,15 +166,21 @@ func getPublicKey(keyId string, config KeycloakConfig) (interface{}, error) {
 	return nil, errors.New("no support for keys of type " + keyEntry.Kty)
 }
 
-func getPublicKeyFromCacheOrBackend(keyId string, config KeycloakConfig) (KeyEntry, error) {
-	entry, exists := publicKeyCache.Get(keyId)
-	if exists {
-		return entry.(KeyEntry), nil
+// realmURL returns the issuer URL of the realm, or an endpoint below it
+func realmURL(config KeycloakConfig, elems ...string) (string, error) {
+	u, err := url.Parse(config.Url)
+	if err != nil {
+		return "", err
 	}
+	u.Path = path.Join(append([]string{"/", u.Path, "realms", config.Realm}, elems...)...)
+	return u.String(), nil
+}
 
+// certsURL returns the JWKS URL of the realm
+func certsURL(config KeycloakConfig) (string, error) {
 	u, err := url.Parse(config.Url)
 	if err != nil {
-		return KeyEntry{}, err
+		return "", err
 	}
 
 	if config.FullCertsPath != nil {
@@ -125,64 +188,118 @@ func getPublicKeyFromCacheOrBackend(keyId string, config KeycloakConfig) (KeyEnt
 	} else {
 		u.Path = path.Join(u.Path, "realms", config.Realm, "protocol/openid-connect/certs")
 	}
+	return u.String(), nil
+}
 
-	httpClient := http.DefaultClient
-	if config.HTTPClient != nil {
-		httpClient = config.HTTPClient
-	}
-	resp, err := httpClient.Get(u.String())
+// publicKeyCacheKey namespaces the cached keys per realm, key ids are only unique within a realm
+func publicKeyCacheKey(certsURL string, keyId string) string {
+	return certsURL + "#" + keyId
+}
+
+func getPublicKeyFromCacheOrBackend(keyId string, config KeycloakConfig) (KeyEntry, error) {
+	u, err := certsURL(config)
 	if err != nil {
 		return KeyEntry{}, err
 	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
+	entry, exists := publicKeyCache.Get(publicKeyCacheKey(u, keyId))
+	if exists {
+		return entry.(KeyEntry), nil
+	}
 
-	var certs Certs
-	err = json.Unmarshal(body, &certs)
+	httpClient := http.DefaultClient
+	if config.HTTPClient != nil {
+		httpClient = config.HTTPClient
+	}
+	certs, err := fetchCerts(u, httpClient)
 	if err != nil {
 		return KeyEntry{}, err
 	}
 
 	for _, keyIdFromServer := range certs.Keys {
 		if keyIdFromServer.Kid == keyId {
-			publicKeyCache.Set(keyId, keyIdFromServer, cache.DefaultExpiration)
+			publicKeyCache.Set(publicKeyCacheKey(u, keyId), keyIdFromServer, cache.DefaultExpiration)
 			return keyIdFromServer, nil
 		}
 	}
 
-	return KeyEntry{}, errors.New("No public key found with kid " + keyId + " found")
+	return KeyEntry{}, noKeyError(keyId)
 }
 
-func decodeToken(token *oauth2.Token, config KeycloakConfig) (*KeyCloakToken, error) {
+// verifyTokenSignature decodes the token, verifies its signature and maps the custom claims.
+// The typ header is returned for checkTokenType.
+func verifyTokenSignature(token *oauth2.Token, config KeycloakConfig) (*KeyCloakToken, string, error) {
 	keyCloakToken := KeyCloakToken{}
 
 	var err error
-	parsedJWT, err := jwt.ParseSigned(token.AccessToken)
+	parsedJWT, err := parseSignedToken(token.AccessToken, config)
 	if err != nil {
 		glog.Errorf("[Gin-OAuth] jwt not decodable: %s", err)
-		return nil, err
+		return nil, "", err
 	}
 	key, err := getPublicKey(parsedJWT.Headers[0].KeyID, config)
 	if err != nil {
 		glog.Errorf("Failed to get publickey %v", err)
-		return nil, err
+		return nil, "", err
 	}
 
 	err = parsedJWT.Claims(key, &keyCloakToken)
 	if err != nil {
 		glog.Errorf("Failed to get claims JWT:%+v", err)
-		return nil, err
+		return nil, "", err
 	}
 
 	if config.CustomClaimsMapper != nil {
 		err = config.CustomClaimsMapper(parsedJWT, &keyCloakToken)
 		if err != nil {
 			glog.Errorf("Failed to get custom claims JWT:%+v", err)
-			return nil, err
+			return nil, "", err
+		}
+	}
+
+	headerType, _ := parsedJWT.Headers[0].ExtraHeaders[jose.HeaderType].(string)
+	return &keyCloakToken, headerType, nil
+}
+
+func checkTokenType(headerType string, token *KeyCloakToken, config KeycloakConfig) error {
+	allowedTypes := config.AllowedTokenTypes
+	if len(allowedTypes) == 0 {
+		allowedTypes = DefaultAllowedTokenTypes
+	}
+	if !containsFold(allowedTypes, token.Typ) {
+		return fmt.Errorf("%w: typ claim %q", ErrInvalidTokenType, token.Typ)
+	}
+
+	if len(config.AllowedHeaderTypes) == 0 {
+		return nil
+	}
+	headerType = strings.TrimPrefix(strings.ToLower(headerType), "application/")
+	for _, allowed := range config.AllowedHeaderTypes {
+		if strings.TrimPrefix(strings.ToLower(allowed), "application/") == headerType {
+			return nil
 		}
 	}
+	return fmt.Errorf("%w: typ header %q", ErrInvalidTokenType, headerType)
+}
 
-	return &keyCloakToken, nil
+func checkAuthorizedParty(tc *TokenContainer, config KeycloakConfig) bool {
+	if len(config.AuthorizedParties) == 0 {
+		return true
+	}
+	for _, azp := range config.AuthorizedParties {
+		if azp == tc.KeyCloakToken.Azp {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
 }
 
 func isExpired(token *KeyCloakToken) bool {
@@ -194,31 +311,79 @@ func isExpired(token *KeyCloakToken) bool {
 	return now.After(fromUnixTimestamp)
 }
 
-func getTokenContainer(ctx *gin.Context, config KeycloakConfig) (*TokenContainer, bool) {
+func getTokenContainer(ctx *gin.Context, config KeycloakConfig) (*TokenContainer, error) {
 	var oauthToken *oauth2.Token
-	var tc *TokenContainer
 	var err error
 
-	if oauthToken, err = extractToken(ctx.Request); err != nil {
+	fromCookie := ctx.Request.Header.Get("Authorization") == "" && config.TokenCookieName != ""
+	if fromCookie {
+		if oauthToken, err = extractCookieToken(ctx.Request, config.TokenCookieName); err != nil {
+			glog.Errorf("[Gin-OAuth] Can not extract oauth2.Token, caused by: %s", err)
+			return nil, ErrNoToken
+		}
+	} else if oauthToken, err = extractToken(ctx.Request); err != nil {
 		glog.Errorf("[Gin-OAuth] Can not extract oauth2.Token, caused by: %s", err)
-		return nil, false
+		return nil, ErrNoToken
 	}
 	if !oauthToken.Valid() {
 		glog.Infof("[Gin-OAuth] Invalid Token - nil or expired")
-		return nil, false
+		return nil, ErrNoToken
 	}
 
-	if tc, err = GetTokenContainer(oauthToken, config); err != nil {
+	tc, err := verifyToken(ctx, oauthToken, config)
+	if err != nil {
+		return nil, err
+	}
+	if fromCookie && config.CSRF != nil {
+		if err = config.CSRF.protect(ctx, tc.KeyCloakToken.Sub); err != nil {
+			glog.Errorf("[Gin-OAuth] %s", err)
+			return nil, err
+		}
+	}
+	return tc, nil
+}
+
+// verifyToken decodes the token and applies all checks of the config which do not depend on the access rules
+func verifyToken(ctx *gin.Context, oauthToken *oauth2.Token, config KeycloakConfig) (*TokenContainer, error) {
+	var tc *TokenContainer
+	var err error
+
+	if tc, config, err = resolveTokenContainer(oauthToken, config); err != nil {
 		glog.Errorf("[Gin-OAuth] Can not extract TokenContainer, caused by: %s", err)
-		return nil, false
+		if errors.Is(err, ErrUntrustedIssuer) || errors.Is(err, ErrInvalidTokenType) {
+			return nil, err
+		}
+		return nil, ErrNoToken
 	}
 
 	if isExpired(tc.KeyCloakToken) {
 		glog.Errorf("[Gin-OAuth] Keycloak Token has expired")
-		return nil, false
+		return nil, ErrNoToken
+	}
+
+	if config.Revocations != nil {
+		if err = config.Revocations.check(tc.KeyCloakToken, config.Realm); err != nil {
+			glog.Errorf("[Gin-OAuth] %s", err)
+			return nil, err
+		}
+	}
+
+	if err = validateDPoP(ctx.Request, oauthToken, tc.KeyCloakToken, config); err != nil {
+		glog.Errorf("[Gin-OAuth] %s", err)
+		ctx.Header("WWW-Authenticate", dpopChallenge())
+		return nil, err
+	}
+
+	if err = validateCertificateBinding(ctx.Request, tc.KeyCloakToken, config); err != nil {
+		glog.Errorf("[Gin-OAuth] %s", err)
+		return nil, err
 	}
 
-	return tc, true
+	if config.UserInfo != nil {
+		config.UserInfo.enrich(ctx.Request.Context(), oauthToken, tc.KeyCloakToken, config)
+	}
+
+	return tc, nil
 }
 
 func (t *TokenContainer) Valid() bool {
@@ -235,7 +400,54 @@ type KeycloakConfig struct {
 	Realm              string
 	FullCertsPath      *string
 	CustomClaimsMapper ClaimMapperFunc
-	HTTPClient *http.Client
+	HTTPClient         *http.Client
+	RoleHierarchy      *RoleHierarchy
+	// AllowedTokenTypes restricts the typ claim, defaults to DefaultAllowedTokenTypes
+	AllowedTokenTypes []string
+	// AllowedHeaderTypes restricts the JOSE typ header (e.g. "at+jwt"), not checked if empty
+	AllowedHeaderTypes []string
+	// AuthorizedParties restricts the clients (azp claim) which may call the route, not checked if empty
+	AuthorizedParties []string
+	// EnableCORS validates the Origin header against the allowed-origins of the token
+	// and answers preflight requests
+	EnableCORS         bool
+	CORSAllowedMethods []string
+	CORSAllowedHeaders []string
+	CORSExposedHeaders []string
+	CORSMaxAge         time.Duration
+	// RequireDPoP rejects tokens which are not DPoP bound, bound tokens are always checked
+	RequireDPoP     bool
+	DPoPProofMaxAge time.Duration
+	// DPoPExternalURL is the scheme and host clients use to reach the service, e.g. behind a reverse proxy
+	DPoPExternalURL string
+	// RequireCertificateBoundTokens rejects tokens without cnf.x5t#S256, bound tokens are always checked
+	RequireCertificateBoundTokens bool
+	// ClientCertHeader is the header a trusted TLS terminating proxy forwards the client certificate in,
+	// if empty the TLS peer certificate of the request is used
+	ClientCertHeader string
+	// DecryptionKeys are the private keys (RSA-OAEP, ECDH-ES) used to decrypt encrypted tokens
+	DecryptionKeys []jose.JSONWebKey
+	// JtiStore enables one-time-use tokens, every jti is accepted only once until the token expires.
+	// The jti is recorded when access is granted. Not for Login sessions or TokenCookieName.
+	JtiStore JtiStore
+	// Revocations rejects tokens revoked by Keycloak through PushNotBeforeHandler or BackchannelLogoutHandler
+	Revocations *RevocationStore
+	// TokenCookieName is the cookie the access token is read from if there is no Authorization header
+	TokenCookieName string
+	// CSRF protects requests authenticated by TokenCookieName or a Login session, strongly recommended for both
+	CSRF *CSRFProtection
+	// UserInfo completes lean tokens with the claims of the userinfo endpoint
+	UserInfo *UserInfoEnricher
+	// TrustedIssuers replace Url, Realm, FullCertsPath and KeySource: tokens are verified with the
+	// keys of the trusted issuer matching their iss claim, tokens of other issuers are rejected
+	TrustedIssuers []TrustedIssuer
+	// KeySource provides the keys to verify tokens, defaults to the certs endpoint of the realm
+	KeySource KeySource
+	// TokenCache skips parsing and signature verification of tokens verified before by the same
+	// middleware, each Auth, AuthChain, AuthResolver and Login keeps its own entries
+	TokenCache *TokenCache
+	// tokenCacheScope separates the TokenCache entries of middlewares with different settings
+	tokenCacheScope uint64
 }
 
 func Auth(accessCheckFunction AccessCheckFunction, endpoints KeycloakConfig) gin.HandlerFunc {
@@ -243,50 +455,90 @@ func Auth(accessCheckFunction AccessCheckFunction, endpoints KeycloakConfig) gin
 }
 
 func authChain(config KeycloakConfig, accessCheckFunctions ...AccessCheckFunction) gin.HandlerFunc {
+	if config.TokenCookieName != "" && config.CSRF == nil {
+		glog.Warningf("[Gin-OAuth] token cookie %s is accepted without CSRF protection", config.TokenCookieName)
+	}
+	config.tokenCacheScope = newTokenCacheScope()
+	if config.TokenCookieName != "" && config.JtiStore != nil {
+		glog.Warningf("[Gin-OAuth] token cookie %s is sent with every request, one-time-use tokens reject all but the first", config.TokenCookieName)
+	}
 	// middleware
 	return func(ctx *gin.Context) {
-		t := time.Now()
-		varianceControl := make(chan bool, 1)
-
-		go func() {
-			tokenContainer, ok := getTokenContainer(ctx, config)
-			if !ok {
-				_ = ctx.AbortWithError(http.StatusUnauthorized, errors.New("No token in context"))
-				varianceControl <- false
-				return
-			}
+		authenticate(ctx, config, accessCheckFunctions)
+	}
+}
 
-			if !tokenContainer.Valid() {
-				_ = ctx.AbortWithError(http.StatusUnauthorized, errors.New("Invalid Token"))
-				varianceControl <- false
-				return
-			}
-			ctx.Set("", tokenContainer.KeyCloakToken)
-			for _, fn := range accessCheckFunctions {
-				if fn(tokenContainer, ctx) {
-					varianceControl <- true
-					return
-				}
-			}
-			_ = ctx.AbortWithError(http.StatusForbidden, errors.New("Access to the Resource is forbidden"))
+// authenticate verifies the token of the request with the config and applies the access rules
+func authenticate(ctx *gin.Context, config KeycloakConfig, accessCheckFunctions []AccessCheckFunction) {
+	t := time.Now()
+	if config.EnableCORS && isPreflight(ctx.Request) {
+		handlePreflight(ctx, config)
+		return
+	}
+	varianceControl := make(chan bool, 1)
+	var verified *TokenContainer
+
+	go func() {
+		tokenContainer, err := getTokenContainer(ctx, config)
+		if errors.Is(err, ErrCSRF) {
+			_ = ctx.AbortWithError(http.StatusForbidden, err)
 			varianceControl <- false
 			return
-		}()
+		}
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusUnauthorized, err)
+			varianceControl <- false
+			return
+		}
 
-		select {
-		case ok := <-varianceControl:
-			if !ok {
-				glog.V(2).Infof("[Gin-OAuth] %12v %s access not allowed", time.Since(t), ctx.Request.URL.Path)
-				return
-			}
-		case <-time.After(VarianceTimer):
-			_ = ctx.AbortWithError(http.StatusGatewayTimeout, errors.New("Authorization check overtime"))
-			glog.V(2).Infof("[Gin-OAuth] %12v %s overtime", time.Since(t), ctx.Request.URL.Path)
+		if !tokenContainer.Valid() {
+			_ = ctx.AbortWithError(http.StatusUnauthorized, errors.New("Invalid Token"))
+			varianceControl <- false
+			return
+		}
+		verified = tokenContainer
+		varianceControl <- checkAccess(tokenContainer, ctx, config, accessCheckFunctions)
+	}()
+
+	select {
+	case ok := <-varianceControl:
+		if !ok {
+			glog.V(2).Infof("[Gin-OAuth] %12v %s access not allowed", time.Since(t), ctx.Request.URL.Path)
 			return
 		}
+	case <-time.After(VarianceTimer):
+		_ = ctx.AbortWithError(http.StatusGatewayTimeout, errors.New("Authorization check overtime"))
+		glog.V(2).Infof("[Gin-OAuth] %12v %s overtime", time.Since(t), ctx.Request.URL.Path)
+		return
+	}
+
+	// the request is replaced here and not in the goroutine, which may outlive the timeout
+	withTokenContainer(ctx, verified)
+
+	glog.V(2).Infof("[Gin-OAuth] %12v %s access allowed", time.Since(t), ctx.Request.URL.Path)
+}
 
-		glog.V(2).Infof("[Gin-OAuth] %12v %s access allowed", time.Since(t), ctx.Request.URL.Path)
+// checkAccess applies the access rules to a verified token and aborts the request if none matches
+func checkAccess(tokenContainer *TokenContainer, ctx *gin.Context, config KeycloakConfig, accessCheckFunctions []AccessCheckFunction) bool {
+	if !checkAuthorizedParty(tokenContainer, config) {
+		_ = ctx.AbortWithError(http.StatusForbidden, ErrUnauthorizedParty)
+		return false
+	}
+	if config.EnableCORS && !checkOrigin(tokenContainer, ctx, config) {
+		_ = ctx.AbortWithError(http.StatusForbidden, ErrOriginNotAllowed)
+		return false
+	}
+	ctx.Set("", tokenContainer.KeyCloakToken)
+	for _, fn := range accessCheckFunctions {
+		if fn(tokenContainer, ctx) {
+			return useOneTimeToken(tokenContainer, ctx, config)
+		}
+		if ctx.IsAborted() {
+			return false
+		}
 	}
+	_ = ctx.AbortWithError(http.StatusForbidden, errors.New("Access to the Resource is forbidden"))
+	return false
 }
 
 func RequestLogger(keys []string, contentKey string) gin.HandlerFunc {
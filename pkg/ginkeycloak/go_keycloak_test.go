@@ -20,6 +20,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
 	"gopkg.in/go-jose/go-jose.v2"
 	"gopkg.in/go-jose/go-jose.v2/jwt"
 )
@@ -127,7 +128,6 @@ func setupRSA(keyCloakToken KeyCloakToken) {
 		panic(err)
 	}
 
-	raw, _ := jwt.ParseSigned(signedTokenRsa)
 	publicKey := pubKey.(*rsa.PublicKey)
 	be := big.NewInt(int64(publicKey.E))
 	ke := KeyEntry{
@@ -138,14 +138,46 @@ func setupRSA(keyCloakToken KeyCloakToken) {
 		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
 		E:   base64.RawURLEncoding.EncodeToString(be.Bytes()),
 	}
-	_ = publicKeyCache.Add(raw.Headers[0].KeyID, ke, time.Minute)
+	addTestKey(ke)
 
 	tokens = append(tokens, signedTokenRsa)
 }
 
+// testKeys holds the public keys of the test tokens, they are cached for the realm of an empty
+// KeycloakConfig and served by fakeKeycloak
+var testKeys []KeyEntry
+
+func addTestKey(ke KeyEntry) {
+	testKeys = append(testKeys, ke)
+	cacheTestKeys(KeycloakConfig{})
+}
+
+// cacheTestKeys makes the test keys available to configs with a realm but without Keycloak
+func cacheTestKeys(config KeycloakConfig) {
+	u, _ := certsURL(config)
+	for _, ke := range testKeys {
+		publicKeyCache.Set(publicKeyCacheKey(u, ke.Kid), ke, time.Minute)
+	}
+}
+
+func signRSAToken(claims interface{}) string {
+	privBlock, _ := pem.Decode([]byte(dummyPrivateKey))
+	privKey, _ := x509.ParsePKCS1PrivateKey(privBlock.Bytes)
+	sigRsa, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: privKey}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "1"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	signedToken, err := jwt.Signed(sigRsa).Claims(claims).CompactSerialize()
+	if err != nil {
+		panic(err)
+	}
+	return signedToken
+}
+
 func createToken(expiredDate time.Time) KeyCloakToken {
 	token := KeyCloakToken{}
 	token.Exp = expiredDate.Unix()
+	token.Typ = "Bearer"
 	token.ResourceAccess = make(map[string]ServiceRole)
 	token.ResourceAccess[serviceName] = ServiceRole{[]string{validRole}}
 	token.PreferredUsername = validUsername
@@ -167,7 +199,6 @@ func SetupEC(keyCloakToken KeyCloakToken) {
 	if err != nil {
 		panic(err)
 	}
-	raw, _ := jwt.ParseSigned(signedTokenEc)
 	ke := KeyEntry{
 		Kid: "2",
 		Kty: "EC",
@@ -177,7 +208,7 @@ func SetupEC(keyCloakToken KeyCloakToken) {
 		X:   base64.RawURLEncoding.EncodeToString(dummyECKey.X.Bytes()),
 		Y:   base64.RawURLEncoding.EncodeToString(dummyECKey.Y.Bytes()),
 	}
-	_ = publicKeyCache.Add(raw.Headers[0].KeyID, ke, time.Minute)
+	addTestKey(ke)
 	tokens = append(tokens, signedTokenEc)
 }
 
@@ -364,6 +395,46 @@ func Test_Auth_all_valid(t *testing.T) {
 	}
 }
 
+func Test_Auth_scheme_is_case_insensitive(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{})
+
+	for _, token := range tokens {
+		ctx := buildContext(token)
+		ctx.Request.Header.Set("Authorization", "bearer "+token)
+		authFunc(ctx)
+
+		assert.True(t, len(ctx.Errors) == 0)
+	}
+}
+
+func Test_Auth_rejects_id_token(t *testing.T) {
+	token := createToken(time.Now().Add(time.Minute))
+	token.Typ = "ID"
+	authFunc := Auth(AuthCheck(), KeycloakConfig{})
+
+	ctx := buildContext(signRSAToken(token))
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrInvalidTokenType))
+}
+
+func Test_Auth_header_type(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{AllowedHeaderTypes: []string{"at+jwt"}})
+
+	ctx := buildContext(tokens[0])
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrInvalidTokenType))
+
+	authFunc = Auth(AuthCheck(), KeycloakConfig{AllowedHeaderTypes: []string{"JWT"}})
+	ctx = buildContext(tokens[0])
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 0)
+}
+
 func customCheck() func(tc *TokenContainer, ctx *gin.Context) bool {
 	authCheck := AuthCheck() // default auth check
 	return func(tc *TokenContainer, ctx *gin.Context) bool {
@@ -412,3 +483,7 @@ func buildContext(token string) *gin.Context {
 	ctx.Request.Header.Set("Authorization", "Bearer "+token)
 	return ctx
 }
+
+func tokenFromString(token string) *oauth2.Token {
+	return &oauth2.Token{AccessToken: token, TokenType: "Bearer"}
+}
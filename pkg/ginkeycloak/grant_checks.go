@@ -18,13 +18,8 @@ func GroupCheck(at []AccessTuple) func(tc *TokenContainer, ctx *gin.Context) boo
 		addTokenToContext(tc, ctx)
 		for idx := range ats {
 			at := ats[idx]
-			if tc.KeyCloakToken.ResourceAccess != nil {
-				serviceRoles := tc.KeyCloakToken.ResourceAccess[at.Service]
-				for _, role := range serviceRoles.Roles {
-					if role == at.Role {
-						return true
-					}
-				}
+			if tc.hasClientRole(at.Service, at.Role) {
+				return true
 			}
 		}
 		return false
@@ -36,10 +31,8 @@ func RealmCheck(allowedRoles []string) func(tc *TokenContainer, ctx *gin.Context
 	return func(tc *TokenContainer, ctx *gin.Context) bool {
 		addTokenToContext(tc, ctx)
 		for _, allowedRole := range allowedRoles {
-			for _, role := range tc.KeyCloakToken.RealmAccess.Roles {
-				if role == allowedRole {
-					return true
-				}
+			if tc.hasRole(RoleRef{Role: allowedRole}) {
+				return true
 			}
 		}
 		return false
@@ -0,0 +1,155 @@
+package ginkeycloak
+
+import (
+	"crypto"
+	_ "crypto/sha256" // hash functions of at_hash and c_hash
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+)
+
+// IDTokenLeeway is the tolerated clock difference for exp and iat of id tokens
+var IDTokenLeeway = time.Minute
+
+var ErrInvalidIDToken = errors.New("Invalid id token")
+
+// IDToken holds the claims of an OpenID Connect id token issued by Keycloak
+type IDToken struct {
+	Iss               string       `json:"iss"`
+	Sub               string       `json:"sub"`
+	Aud               jwt.Audience `json:"aud"`
+	Exp               int64        `json:"exp"`
+	Iat               int64        `json:"iat"`
+	AuthTime          int64        `json:"auth_time,omitempty"`
+	Nonce             string       `json:"nonce,omitempty"`
+	Acr               string       `json:"acr,omitempty"`
+	Azp               string       `json:"azp,omitempty"`
+	AtHash            string       `json:"at_hash,omitempty"`
+	CHash             string       `json:"c_hash,omitempty"`
+	Typ               string       `json:"typ,omitempty"`
+	Sid               string       `json:"sid,omitempty"`
+	SessionState      string       `json:"session_state,omitempty"`
+	Name              string       `json:"name,omitempty"`
+	PreferredUsername string       `json:"preferred_username,omitempty"`
+	GivenName         string       `json:"given_name,omitempty"`
+	FamilyName        string       `json:"family_name,omitempty"`
+	Email             string       `json:"email,omitempty"`
+	EmailVerified     bool         `json:"email_verified,omitempty"`
+}
+
+// IDTokenVerification describes what the id token is expected to be bound to
+type IDTokenVerification struct {
+	// ClientID must be an audience of the token and, if present, its azp
+	ClientID string
+	// Nonce must match the nonce claim if set
+	Nonce string
+	// AccessToken must match the at_hash claim if set and the claim is present
+	AccessToken string
+	// Code must match the c_hash claim if set, the claim is then required
+	Code string
+}
+
+func idTokenError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidIDToken}, args...)...)
+}
+
+// VerifyIDToken verifies signature and claims of an id token with the keys of the realm. The
+// issuer must be the realm of Url or one of the TrustedIssuers, configs with neither are rejected.
+func VerifyIDToken(rawIDToken string, config KeycloakConfig, verification IDTokenVerification) (*IDToken, error) {
+	config, issuer, err := trustedIssuer(rawIDToken, config)
+	if err != nil {
+		return nil, err
+	}
+	if issuer == "" {
+		if config.Url == "" {
+			return nil, idTokenError("no expected issuer, set Url or TrustedIssuers")
+		}
+		if issuer, err = realmURL(config); err != nil {
+			return nil, err
+		}
+	}
+	parsedJWT, err := parseSignedToken(rawIDToken, config)
+	if err != nil {
+		return nil, idTokenError("not decodable: %s", err)
+	}
+	key, err := getPublicKey(parsedJWT.Headers[0].KeyID, config)
+	if err != nil {
+		glog.Errorf("Failed to get publickey %v", err)
+		return nil, err
+	}
+	var idToken IDToken
+	if err = parsedJWT.Claims(key, &idToken); err != nil {
+		return nil, idTokenError("%s", err)
+	}
+
+	if idToken.Iss != issuer {
+		return nil, idTokenError("issuer %q does not match %q", idToken.Iss, issuer)
+	}
+	if idToken.Typ != "" && idToken.Typ != "ID" {
+		return nil, idTokenError("typ %q is not an id token", idToken.Typ)
+	}
+	if !idToken.Aud.Contains(verification.ClientID) {
+		return nil, idTokenError("audience does not contain %q", verification.ClientID)
+	}
+	if len(idToken.Aud) > 1 && idToken.Azp == "" {
+		return nil, idTokenError("azp required for multiple audiences")
+	}
+	if idToken.Azp != "" && idToken.Azp != verification.ClientID {
+		return nil, idTokenError("azp %q does not match %q", idToken.Azp, verification.ClientID)
+	}
+
+	now := time.Now()
+	if idToken.Exp == 0 || now.Add(-IDTokenLeeway).After(time.Unix(idToken.Exp, 0)) {
+		return nil, idTokenError("expired")
+	}
+	if now.Add(IDTokenLeeway).Before(time.Unix(idToken.Iat, 0)) {
+		return nil, idTokenError("issued in the future")
+	}
+
+	if verification.Nonce != "" && subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(verification.Nonce)) != 1 {
+		return nil, idTokenError("nonce does not match")
+	}
+	alg := parsedJWT.Headers[0].Algorithm
+	if verification.AccessToken != "" && idToken.AtHash != "" {
+		if err = checkTokenHash(idToken.AtHash, verification.AccessToken, alg); err != nil {
+			return nil, idTokenError("at_hash %s", err)
+		}
+	}
+	if verification.Code != "" {
+		if err = checkTokenHash(idToken.CHash, verification.Code, alg); err != nil {
+			return nil, idTokenError("c_hash %s", err)
+		}
+	}
+	return &idToken, nil
+}
+
+// checkTokenHash validates at_hash and c_hash: the left half of the hash of the value, using
+// the hash function of the signature algorithm
+func checkTokenHash(expected string, value string, alg string) error {
+	var hash crypto.Hash
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		hash = crypto.SHA256
+	case strings.HasSuffix(alg, "384"):
+		hash = crypto.SHA384
+	case strings.HasSuffix(alg, "512"):
+		hash = crypto.SHA512
+	default:
+		return errors.New("unsupported algorithm " + alg)
+	}
+	h := hash.New()
+	h.Write([]byte(value))
+	sum := h.Sum(nil)
+	actual := base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+	if subtle.ConstantTimeCompare([]byte(actual), []byte(expected)) != 1 {
+		return errors.New("does not match")
+	}
+	return nil
+}
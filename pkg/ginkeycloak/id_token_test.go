@@ -0,0 +1,94 @@
+package ginkeycloak
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var idTokenConfig = KeycloakConfig{Url: "https://keycloak.example.com", Realm: "test"}
+
+func idTokenClaims() map[string]interface{} {
+	cacheTestKeys(idTokenConfig)
+	return map[string]interface{}{
+		"iss":   "https://keycloak.example.com/realms/test",
+		"sub":   "user",
+		"aud":   loginClientID,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": "n-0S6_WzA2Mj",
+		"typ":   "ID",
+	}
+}
+
+func leftHalfHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+func Test_VerifyIDToken(t *testing.T) {
+	claims := idTokenClaims()
+	claims["at_hash"] = leftHalfHash("access-token")
+	claims["c_hash"] = leftHalfHash("code")
+
+	idToken, err := VerifyIDToken(signRSAToken(claims), idTokenConfig, IDTokenVerification{
+		ClientID:    loginClientID,
+		Nonce:       "n-0S6_WzA2Mj",
+		AccessToken: "access-token",
+		Code:        "code",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "user", idToken.Sub)
+}
+
+func Test_VerifyIDToken_invalid(t *testing.T) {
+	verification := IDTokenVerification{ClientID: loginClientID, Nonce: "n-0S6_WzA2Mj", AccessToken: "access-token"}
+	cases := map[string]func(claims map[string]interface{}){
+		"wrong audience":   func(claims map[string]interface{}) { claims["aud"] = "other-client" },
+		"wrong azp":        func(claims map[string]interface{}) { claims["azp"] = "other-client" },
+		"missing azp":      func(claims map[string]interface{}) { claims["aud"] = []string{loginClientID, "other-client"} },
+		"wrong nonce":      func(claims map[string]interface{}) { claims["nonce"] = "replayed" },
+		"wrong at_hash":    func(claims map[string]interface{}) { claims["at_hash"] = leftHalfHash("other-token") },
+		"expired":          func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"access token typ": func(claims map[string]interface{}) { claims["typ"] = "Bearer" },
+		"wrong issuer":     func(claims map[string]interface{}) { claims["iss"] = "https://evil.example.com/realms/test" },
+	}
+	for name, modify := range cases {
+		claims := idTokenClaims()
+		modify(claims)
+
+		_, err := VerifyIDToken(signRSAToken(claims), idTokenConfig, verification)
+
+		assert.True(t, errors.Is(err, ErrInvalidIDToken), name)
+	}
+}
+
+func Test_VerifyIDToken_requires_c_hash(t *testing.T) {
+	_, err := VerifyIDToken(signRSAToken(idTokenClaims()), idTokenConfig, IDTokenVerification{ClientID: loginClientID, Code: "code"})
+
+	assert.True(t, errors.Is(err, ErrInvalidIDToken))
+}
+
+func Test_VerifyIDToken_requires_expected_issuer(t *testing.T) {
+	_, err := VerifyIDToken(signRSAToken(idTokenClaims()), KeycloakConfig{}, IDTokenVerification{ClientID: loginClientID})
+
+	assert.True(t, errors.Is(err, ErrInvalidIDToken))
+}
+
+func Test_VerifyIDToken_trusted_issuers(t *testing.T) {
+	config := KeycloakConfig{TrustedIssuers: []TrustedIssuer{{Url: "https://keycloak.example.com", Realm: "test"}}}
+
+	idToken, err := VerifyIDToken(signRSAToken(idTokenClaims()), config, IDTokenVerification{ClientID: loginClientID})
+	assert.NoError(t, err)
+	assert.Equal(t, "user", idToken.Sub)
+
+	claims := idTokenClaims()
+	claims["iss"] = "https://evil.example.com/realms/test"
+	_, err = VerifyIDToken(signRSAToken(claims), config, IDTokenVerification{ClientID: loginClientID})
+	assert.True(t, errors.Is(err, ErrUntrustedIssuer))
+}
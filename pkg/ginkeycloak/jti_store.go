@@ -0,0 +1,102 @@
+package ginkeycloak
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+)
+
+// DefaultJtiTTL is how long the jti of tokens without exp claim is remembered
+var DefaultJtiTTL = 24 * time.Hour
+
+var ErrTokenReplayed = errors.New("Token has already been used")
+
+// ErrJtiStoreUnavailable is returned if the JtiStore fails, the request is rejected with 503
+var ErrJtiStoreUnavailable = errors.New("One-time-use store unavailable")
+
+// JtiStore records the jti of one-time-use tokens until they expire
+type JtiStore interface {
+	// Use records the jti until expiry and returns false if it has been recorded before
+	Use(jti string, expiry time.Time) (bool, error)
+}
+
+type memoryJtiStore struct {
+	cache *lruCache
+}
+
+// NewMemoryJtiStore keeps up to maxEntries jti values in memory. If more unexpired tokens are
+// seen, the least recently used are evicted and could be replayed, so size it generously.
+func NewMemoryJtiStore(maxEntries int) JtiStore {
+	return &memoryJtiStore{cache: newLRUCache(maxEntries)}
+}
+
+func (s *memoryJtiStore) Use(jti string, expiry time.Time) (bool, error) {
+	return s.cache.add(jti, true, expiry), nil
+}
+
+// RedisClient is the subset of a Redis client used by the Redis backed stores,
+// wrap e.g. go-redis to implement it
+type RedisClient interface {
+	// SetNX sets key to value with the ttl if it does not exist yet and reports whether it was set
+	SetNX(key string, value string, ttl time.Duration) (bool, error)
+}
+
+type redisJtiStore struct {
+	client RedisClient
+	prefix string
+}
+
+func NewRedisJtiStore(client RedisClient, prefix string) JtiStore {
+	return &redisJtiStore{client: client, prefix: prefix}
+}
+
+func (s *redisJtiStore) Use(jti string, expiry time.Time) (bool, error) {
+	ttl := time.Until(expiry)
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+	return s.client.SetNX(s.prefix+jti, "1", ttl)
+}
+
+func checkOneTimeUse(token *KeyCloakToken, config KeycloakConfig) error {
+	if config.JtiStore == nil {
+		return nil
+	}
+	if token.Jti == "" {
+		return fmt.Errorf("%w: token has no jti", ErrTokenReplayed)
+	}
+	expiry := time.Now().Add(DefaultJtiTTL)
+	if token.Exp != 0 {
+		expiry = time.Unix(token.Exp, 0)
+	}
+	firstUse, err := config.JtiStore.Use(token.Iss+"|"+token.Jti, expiry)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrJtiStoreUnavailable, err)
+	}
+	if !firstUse {
+		return ErrTokenReplayed
+	}
+	return nil
+}
+
+// useOneTimeToken records the jti once access has been granted, so requests rejected by the
+// access rules do not use up the token. If the store fails the request is rejected with 503,
+// so an outage can not be used to replay tokens
+func useOneTimeToken(tc *TokenContainer, ctx *gin.Context, config KeycloakConfig) bool {
+	err := checkOneTimeUse(tc.KeyCloakToken, config)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, ErrJtiStoreUnavailable) {
+		glog.Errorf("[Gin-OAuth] %s", err)
+		_ = ctx.AbortWithError(http.StatusServiceUnavailable, ErrJtiStoreUnavailable)
+		return false
+	}
+	glog.Warningf("[Gin-OAuth] rejected token of %s: %s", tc.KeyCloakToken.Sub, err)
+	_ = ctx.AbortWithError(http.StatusUnauthorized, err)
+	return false
+}
@@ -0,0 +1,120 @@
+package ginkeycloak
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRedis struct {
+	keys map[string]time.Duration
+}
+
+func (r *fakeRedis) SetNX(key string, value string, ttl time.Duration) (bool, error) {
+	if _, ok := r.keys[key]; ok {
+		return false, nil
+	}
+	r.keys[key] = ttl
+	return true, nil
+}
+
+type failingRedis struct{}
+
+func (r failingRedis) SetNX(key string, value string, ttl time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func oneTimeToken(jti string) string {
+	token := createToken(time.Now().Add(time.Minute))
+	token.Jti = jti
+	return signRSAToken(token)
+}
+
+func Test_JtiStore_rejects_reuse(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{JtiStore: NewMemoryJtiStore(100)})
+	token := oneTimeToken("payment-1")
+
+	ctx := buildContext(token)
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+
+	ctx = buildContext(token)
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.Equal(t, ErrTokenReplayed, ctx.Errors[0].Err)
+}
+
+func Test_JtiStore_forbidden_request_keeps_token(t *testing.T) {
+	config := KeycloakConfig{JtiStore: NewMemoryJtiStore(100)}
+	token := oneTimeToken("payment-2")
+
+	ctx := buildContext(token)
+	Auth(RealmCheck([]string{invalidRealm}), config)(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.Equal(t, "Access to the Resource is forbidden", ctx.Errors[0].Err.Error())
+
+	ctx = buildContext(token)
+	Auth(AuthCheck(), config)(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+}
+
+func Test_JtiStore_refused_for_login(t *testing.T) {
+	config := KeycloakConfig{Url: "http://keycloak", Realm: "test", JtiStore: NewMemoryJtiStore(100)}
+	_, err := NewLogin(LoginConfig{KeycloakConfig: config, CookieSecret: []byte("0123456789abcdef")})
+	assert.Error(t, err)
+}
+
+func Test_JtiStore_requires_jti(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{JtiStore: NewMemoryJtiStore(100)})
+
+	ctx := buildContext(tokens[0])
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrTokenReplayed))
+}
+
+func Test_JtiStore_redis(t *testing.T) {
+	client := &fakeRedis{keys: map[string]time.Duration{}}
+	store := NewRedisJtiStore(client, "jti:")
+
+	firstUse, err := store.Use("abc", time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, firstUse)
+	firstUse, _ = store.Use("abc", time.Now().Add(time.Minute))
+	assert.False(t, firstUse)
+	assert.Contains(t, client.keys, "jti:abc")
+}
+
+func Test_LRUCache_evicts_least_recently_used(t *testing.T) {
+	cache := newLRUCache(2)
+	expiry := time.Now().Add(time.Minute)
+	cache.set("a", 1, expiry)
+	cache.set("b", 2, expiry)
+	cache.get("a")
+	cache.set("c", 3, expiry)
+
+	_, ok := cache.get("b")
+	assert.False(t, ok)
+	value, ok := cache.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	cache.set("d", 4, time.Now().Add(-time.Second))
+	_, ok = cache.get("d")
+	assert.False(t, ok)
+}
+
+func Test_JtiStore_failure_is_unavailable(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{JtiStore: NewRedisJtiStore(failingRedis{}, "jti:")})
+
+	ctx := buildContext(oneTimeToken("payment-3"))
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.Equal(t, ErrJtiStoreUnavailable, ctx.Errors[0].Err)
+	assert.Equal(t, http.StatusServiceUnavailable, ctx.Writer.Status())
+}
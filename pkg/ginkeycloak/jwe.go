@@ -0,0 +1,67 @@
+package ginkeycloak
+
+import (
+	"errors"
+	"strings"
+
+	"gopkg.in/go-jose/go-jose.v2"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+)
+
+// AllowedKeyEncryptionAlgorithms are the JWE key management algorithms accepted for encrypted tokens
+var AllowedKeyEncryptionAlgorithms = []jose.KeyAlgorithm{
+	jose.RSA_OAEP,
+	jose.RSA_OAEP_256,
+	jose.ECDH_ES,
+	jose.ECDH_ES_A128KW,
+	jose.ECDH_ES_A192KW,
+	jose.ECDH_ES_A256KW,
+}
+
+var ErrTokenEncrypted = errors.New("Token is encrypted but no matching decryption key is configured")
+
+func isEncrypted(rawToken string) bool {
+	return strings.Count(rawToken, ".") == 4
+}
+
+// parseSignedToken returns the signed JWT, decrypting nested JWE-in-JWS tokens with the
+// KeycloakConfig.DecryptionKeys first
+func parseSignedToken(rawToken string, config KeycloakConfig) (*jwt.JSONWebToken, error) {
+	if !isEncrypted(rawToken) {
+		return jwt.ParseSigned(rawToken)
+	}
+	if len(config.DecryptionKeys) == 0 {
+		return nil, ErrTokenEncrypted
+	}
+
+	nested, err := jwt.ParseSignedAndEncrypted(rawToken)
+	if err != nil {
+		return nil, err
+	}
+	header := nested.Headers[0]
+	if !isAllowedKeyEncryptionAlgorithm(header.Algorithm) {
+		return nil, errors.New("Key encryption algorithm not supported " + header.Algorithm)
+	}
+
+	for _, key := range config.DecryptionKeys {
+		if header.KeyID != "" && key.KeyID != "" && key.KeyID != header.KeyID {
+			continue
+		}
+		if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+			continue
+		}
+		if parsedJWT, err := nested.Decrypt(key.Key); err == nil {
+			return parsedJWT, nil
+		}
+	}
+	return nil, ErrTokenEncrypted
+}
+
+func isAllowedKeyEncryptionAlgorithm(alg string) bool {
+	for _, allowed := range AllowedKeyEncryptionAlgorithms {
+		if string(allowed) == alg {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package ginkeycloak
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/go-jose/go-jose.v2"
+)
+
+func encryptToken(signedToken string, alg jose.KeyAlgorithm, key interface{}) string {
+	encrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: alg, Key: key, KeyID: "enc"},
+		(&jose.EncrypterOptions{}).WithContentType("JWT"))
+	if err != nil {
+		panic(err)
+	}
+	jwe, err := encrypter.Encrypt([]byte(signedToken))
+	if err != nil {
+		panic(err)
+	}
+	serialized, _ := jwe.CompactSerialize()
+	return serialized
+}
+
+func Test_JWE_RSA_OAEP(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	encrypted := encryptToken(signRSAToken(createToken(time.Now().Add(time.Minute))), jose.RSA_OAEP, &rsaKey.PublicKey)
+	authFunc := Auth(AuthCheck(), KeycloakConfig{DecryptionKeys: []jose.JSONWebKey{{Key: rsaKey, KeyID: "enc"}}})
+
+	ctx := buildContext(encrypted)
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 0)
+}
+
+func Test_JWE_ECDH_ES(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	encrypted := encryptToken(signRSAToken(createToken(time.Now().Add(time.Minute))), jose.ECDH_ES_A128KW, &ecKey.PublicKey)
+	authFunc := Auth(AuthCheck(), KeycloakConfig{DecryptionKeys: []jose.JSONWebKey{{Key: otherKey}, {Key: ecKey}}})
+
+	ctx := buildContext(encrypted)
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 0)
+}
+
+func Test_JWE_without_decryption_key(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	encrypted := encryptToken(signRSAToken(createToken(time.Now().Add(time.Minute))), jose.RSA_OAEP, &rsaKey.PublicKey)
+
+	_, err := GetTokenContainer(tokenFromString(encrypted), KeycloakConfig{})
+
+	assert.True(t, errors.Is(err, ErrTokenEncrypted))
+}
+
+func Test_JWE_trusted_issuer(t *testing.T) {
+	cacheTestKeys(KeycloakConfig{Url: "https://old.example.com", Realm: "shop"})
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	config := KeycloakConfig{
+		DecryptionKeys: []jose.JSONWebKey{{Key: rsaKey}},
+		TrustedIssuers: []TrustedIssuer{{Url: "https://old.example.com", Realm: "shop"}},
+	}
+
+	tokenContainer, err := GetTokenContainer(tokenFromString(encryptToken(realmToken("https://old.example.com/realms/shop"), jose.RSA_OAEP, &rsaKey.PublicKey)), config)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://old.example.com/realms/shop", tokenContainer.KeyCloakToken.Iss)
+
+	_, err = GetTokenContainer(tokenFromString(encryptToken(realmToken("https://evil.example.com/realms/shop"), jose.RSA_OAEP, &rsaKey.PublicKey)), config)
+	assert.True(t, errors.Is(err, ErrUntrustedIssuer))
+}
@@ -0,0 +1,241 @@
+package ginkeycloak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// JWKSMaxSize limits the size of JWKS responses
+var JWKSMaxSize int64 = 1 << 20
+
+// JWKSTimeout limits every JWKS request
+var JWKSTimeout = 10 * time.Second
+
+// JWKSRetries is how often a failed JWKS request is retried, with exponential backoff starting at
+// JWKSRetryBackoff and capped at JWKSMaxRetryBackoff, each with random jitter. A Retry-After
+// header of 429 and 503 responses is honoured.
+var JWKSRetries = 2
+var JWKSRetryBackoff = 200 * time.Millisecond
+var JWKSMaxRetryBackoff = 5 * time.Second
+
+// JWKSMaxDuration limits a fetch including all retries, keep it below VarianceTimer
+var JWKSMaxDuration = 20 * time.Second
+
+// JWKSBreakerThreshold consecutive failed fetches open the circuit breaker of a JWKS URL, further
+// fetches fail immediately until JWKSBreakerCooldown has passed. Then a single request probes the
+// URL, it closes the breaker on success and opens it again on failure.
+var JWKSBreakerThreshold = 3
+var JWKSBreakerCooldown = 30 * time.Second
+
+var ErrJWKSUnavailable = errors.New("JWKS endpoint unavailable, circuit breaker open")
+
+// JWKSError describes a failed JWKS fetch
+type JWKSError struct {
+	URL        string
+	StatusCode int
+	Err        error
+	retryAfter time.Duration
+}
+
+func (e *JWKSError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("GET %s: status %d: %s", e.URL, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("GET %s: %s", e.URL, e.Err)
+}
+
+func (e *JWKSError) Unwrap() error {
+	return e.Err
+}
+
+// retryable reports whether a later attempt may succeed
+func (e *JWKSError) retryable() bool {
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// parseRetryAfter reads delay-seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+type jwksBreaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+var (
+	jwksBreakersMu sync.Mutex
+	jwksBreakers   = map[string]*jwksBreaker{}
+)
+
+// jwksBreakerAllow reports whether the URL may be fetched and whether the fetch is the single
+// probe of a breaker whose cooldown has passed
+func jwksBreakerAllow(u string) (allowed bool, probe bool) {
+	jwksBreakersMu.Lock()
+	defer jwksBreakersMu.Unlock()
+	breaker, ok := jwksBreakers[u]
+	if !ok || breaker.failures < JWKSBreakerThreshold {
+		return true, false
+	}
+	if breaker.probing || time.Now().Before(breaker.openUntil) {
+		return false, false
+	}
+	breaker.probing = true
+	return true, true
+}
+
+func jwksBreakerRecord(u string, err error) {
+	jwksBreakersMu.Lock()
+	defer jwksBreakersMu.Unlock()
+	if err == nil {
+		delete(jwksBreakers, u)
+		return
+	}
+	breaker, ok := jwksBreakers[u]
+	if !ok {
+		breaker = &jwksBreaker{}
+		jwksBreakers[u] = breaker
+	}
+	breaker.failures++
+	breaker.probing = false
+	if breaker.failures >= JWKSBreakerThreshold {
+		glog.Errorf("[Gin-OAuth] JWKS endpoint %s failed %d times, pausing fetches for %s", u, breaker.failures, JWKSBreakerCooldown)
+		breaker.openUntil = time.Now().Add(JWKSBreakerCooldown)
+	}
+}
+
+type jwksCall struct {
+	done  chan struct{}
+	certs *Certs
+	err   error
+}
+
+var (
+	jwksCallsMu sync.Mutex
+	jwksCalls   = map[string]*jwksCall{}
+)
+
+// fetchCerts gets the JWKS document, concurrent fetches of the same URL share one request
+func fetchCerts(u string, httpClient *http.Client) (*Certs, error) {
+	jwksCallsMu.Lock()
+	if call, ok := jwksCalls[u]; ok {
+		jwksCallsMu.Unlock()
+		<-call.done
+		return call.certs, call.err
+	}
+	call := &jwksCall{done: make(chan struct{})}
+	jwksCalls[u] = call
+	jwksCallsMu.Unlock()
+
+	call.certs, call.err = fetchCertsWithRetries(u, httpClient)
+
+	jwksCallsMu.Lock()
+	delete(jwksCalls, u)
+	jwksCallsMu.Unlock()
+	close(call.done)
+	return call.certs, call.err
+}
+
+// fetchCertsWithRetries retries temporary failures within JWKSMaxDuration
+func fetchCertsWithRetries(u string, httpClient *http.Client) (*Certs, error) {
+	allowed, probe := jwksBreakerAllow(u)
+	if !allowed {
+		return nil, &JWKSError{URL: u, Err: ErrJWKSUnavailable}
+	}
+	retries := JWKSRetries
+	if probe {
+		retries = 0
+	}
+
+	deadline := time.Now().Add(JWKSMaxDuration)
+	var certs *Certs
+	var err *JWKSError
+	backoff := JWKSRetryBackoff
+	for attempt := 0; ; attempt++ {
+		if certs, err = fetchCertsOnce(u, httpClient, deadline); err == nil || !err.retryable() || attempt >= retries {
+			break
+		}
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if err.retryAfter > wait {
+			wait = err.retryAfter
+		}
+		if time.Until(deadline) <= wait {
+			break
+		}
+		glog.Warningf("[Gin-OAuth] %s, retrying in %s", err, wait)
+		time.Sleep(wait)
+		if backoff *= 2; backoff > JWKSMaxRetryBackoff {
+			backoff = JWKSMaxRetryBackoff
+		}
+	}
+	if err != nil {
+		jwksBreakerRecord(u, err)
+		return nil, err
+	}
+	jwksBreakerRecord(u, nil)
+	return certs, nil
+}
+
+func fetchCertsOnce(u string, httpClient *http.Client, deadline time.Time) (*Certs, *JWKSError) {
+	if timeout := time.Now().Add(JWKSTimeout); timeout.Before(deadline) {
+		deadline = timeout
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, &JWKSError{URL: u, Err: err}
+	}
+	req.Header.Set("Accept", "application/json, application/jwk-set+json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, &JWKSError{URL: u, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, JWKSMaxSize))
+		jwksErr := &JWKSError{URL: u, StatusCode: resp.StatusCode, Err: errors.New(http.StatusText(resp.StatusCode))}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			jwksErr.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+		return nil, jwksErr
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/json" && mediaType != "application/jwk-set+json") {
+			return nil, &JWKSError{URL: u, StatusCode: resp.StatusCode, Err: errors.New("unexpected content type " + contentType)}
+		}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, JWKSMaxSize+1))
+	if err != nil {
+		return nil, &JWKSError{URL: u, StatusCode: resp.StatusCode, Err: err}
+	}
+	if int64(len(body)) > JWKSMaxSize {
+		return nil, &JWKSError{URL: u, StatusCode: resp.StatusCode, Err: fmt.Errorf("response exceeds %d bytes", JWKSMaxSize)}
+	}
+	var certs Certs
+	if err = json.Unmarshal(body, &certs); err != nil {
+		return nil, &JWKSError{URL: u, StatusCode: resp.StatusCode, Err: err}
+	}
+	return &certs, nil
+}
@@ -0,0 +1,167 @@
+package ginkeycloak
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newJWKSServer(handler func(w http.ResponseWriter, call int32)) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, atomic.AddInt32(&calls, 1))
+	}))
+	return server, &calls
+}
+
+func writeJWKS(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(Certs{Keys: testKeys})
+}
+
+func Test_fetchCerts_retries(t *testing.T) {
+	defer func(backoff time.Duration) { JWKSRetryBackoff = backoff }(JWKSRetryBackoff)
+	JWKSRetryBackoff = time.Millisecond
+	server, calls := newJWKSServer(func(w http.ResponseWriter, call int32) {
+		if call < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeJWKS(w)
+	})
+	defer server.Close()
+
+	certs, err := fetchCerts(server.URL, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Len(t, certs.Keys, len(testKeys))
+	assert.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func Test_fetchCerts_errors(t *testing.T) {
+	for name, handler := range map[string]func(w http.ResponseWriter, call int32){
+		"status 404": func(w http.ResponseWriter, call int32) {
+			w.WriteHeader(http.StatusNotFound)
+		},
+		"unexpected content type text/html": func(w http.ResponseWriter, call int32) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html>login</html>"))
+		},
+		"exceeds": func(w http.ResponseWriter, call int32) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"keys":[],"padding":"` + strings.Repeat("x", int(JWKSMaxSize)) + `"}`))
+		},
+	} {
+		server, calls := newJWKSServer(handler)
+		_, err := fetchCerts(server.URL, http.DefaultClient)
+		server.Close()
+
+		var jwksErr *JWKSError
+		assert.True(t, errors.As(err, &jwksErr), name)
+		assert.Contains(t, err.Error(), server.URL, name)
+		assert.Contains(t, err.Error(), name, name)
+		assert.Equal(t, int32(1), atomic.LoadInt32(calls), "%s is not retried", name)
+	}
+}
+
+func Test_fetchCerts_circuit_breaker(t *testing.T) {
+	defer func(backoff time.Duration) { JWKSRetryBackoff = backoff }(JWKSRetryBackoff)
+	JWKSRetryBackoff = time.Millisecond
+	server, calls := newJWKSServer(func(w http.ResponseWriter, call int32) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	defer server.Close()
+
+	for i := 0; i < JWKSBreakerThreshold; i++ {
+		_, err := fetchCerts(server.URL, http.DefaultClient)
+		assert.Error(t, err)
+	}
+	fetched := atomic.LoadInt32(calls)
+	assert.Equal(t, int32(JWKSBreakerThreshold*(JWKSRetries+1)), fetched)
+
+	_, err := fetchCerts(server.URL, http.DefaultClient)
+	assert.True(t, errors.Is(err, ErrJWKSUnavailable))
+	assert.Equal(t, fetched, atomic.LoadInt32(calls))
+}
+
+func Test_fetchCerts_half_open_probe(t *testing.T) {
+	defer func(backoff, cooldown time.Duration) {
+		JWKSRetryBackoff, JWKSBreakerCooldown = backoff, cooldown
+	}(JWKSRetryBackoff, JWKSBreakerCooldown)
+	JWKSRetryBackoff, JWKSBreakerCooldown = time.Millisecond, 20*time.Millisecond
+	var healthy int32
+	server, calls := newJWKSServer(func(w http.ResponseWriter, call int32) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		writeJWKS(w)
+	})
+	defer server.Close()
+
+	for i := 0; i < JWKSBreakerThreshold; i++ {
+		_, _ = fetchCerts(server.URL, http.DefaultClient)
+	}
+	time.Sleep(30 * time.Millisecond)
+	fetched := atomic.LoadInt32(calls)
+	_, err := fetchCerts(server.URL, http.DefaultClient)
+	assert.Error(t, err)
+	assert.Equal(t, fetched+1, atomic.LoadInt32(calls), "the probe is a single request")
+	_, err = fetchCerts(server.URL, http.DefaultClient)
+	assert.True(t, errors.Is(err, ErrJWKSUnavailable), "a failed probe opens the breaker again")
+
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(30 * time.Millisecond)
+	_, err = fetchCerts(server.URL, http.DefaultClient)
+	assert.NoError(t, err)
+	_, err = fetchCerts(server.URL, http.DefaultClient)
+	assert.NoError(t, err)
+}
+
+func Test_fetchCerts_shared_by_concurrent_callers(t *testing.T) {
+	server, calls := newJWKSServer(func(w http.ResponseWriter, call int32) {
+		time.Sleep(50 * time.Millisecond)
+		writeJWKS(w)
+	})
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			certs, err := fetchCerts(server.URL, http.DefaultClient)
+			assert.NoError(t, err)
+			assert.Len(t, certs.Keys, len(testKeys))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
+
+func Test_fetchCerts_retry_after(t *testing.T) {
+	defer func(maxDuration time.Duration) { JWKSMaxDuration = maxDuration }(JWKSMaxDuration)
+	JWKSMaxDuration = time.Second
+	server, calls := newJWKSServer(func(w http.ResponseWriter, call int32) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	defer server.Close()
+
+	start := time.Now()
+	_, err := fetchCerts(server.URL, http.DefaultClient)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls), "no retry if Retry-After exceeds the budget")
+	assert.True(t, time.Since(start) < JWKSMaxDuration)
+
+	assert.Equal(t, 2*time.Second, parseRetryAfter("2"))
+	assert.True(t, parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)) > 50*time.Second)
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+}
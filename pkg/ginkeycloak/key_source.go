@@ -0,0 +1,189 @@
+package ginkeycloak
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeySource provides the public keys tokens are verified with
+type KeySource interface {
+	// GetKey returns the key with the key id of the token header
+	GetKey(kid string) (KeyEntry, error)
+}
+
+func keySource(config KeycloakConfig) KeySource {
+	if config.KeySource != nil {
+		return config.KeySource
+	}
+	return NewHTTPKeySource(config)
+}
+
+type httpKeySource struct {
+	config KeycloakConfig
+}
+
+// NewHTTPKeySource fetches the keys from the certs endpoint of the realm and caches them,
+// this is the default if KeycloakConfig.KeySource is not set
+func NewHTTPKeySource(config KeycloakConfig) KeySource {
+	return httpKeySource{config: config}
+}
+
+func (s httpKeySource) GetKey(kid string) (KeyEntry, error) {
+	return getPublicKeyFromCacheOrBackend(kid, s.config)
+}
+
+type staticKeySource struct {
+	keys map[string]KeyEntry
+}
+
+func noKeyError(kid string) error {
+	return errors.New("No public key found with kid " + kid + " found")
+}
+
+// NewStaticKeySource serves the keys of a JWKS document, e.g. saved from the certs endpoint
+func NewStaticKeySource(jwks []byte) (KeySource, error) {
+	var certs Certs
+	if err := json.Unmarshal(jwks, &certs); err != nil {
+		return nil, err
+	}
+	return newStaticKeySource(certs), nil
+}
+
+func newStaticKeySource(certs Certs) staticKeySource {
+	keys := map[string]KeyEntry{}
+	for _, key := range certs.Keys {
+		keys[key.Kid] = key
+	}
+	return staticKeySource{keys: keys}
+}
+
+func (s staticKeySource) GetKey(kid string) (KeyEntry, error) {
+	key, ok := s.keys[kid]
+	if !ok {
+		return KeyEntry{}, noKeyError(kid)
+	}
+	return key, nil
+}
+
+type fileKeySource struct {
+	path     string
+	interval time.Duration
+
+	mu        sync.Mutex
+	keys      staticKeySource
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewFileKeySource serves the keys of a JWKS file. The file is checked for changes at most once
+// per interval, so rotated keys can be deployed without restart.
+func NewFileKeySource(path string, interval time.Duration) (KeySource, error) {
+	s := &fileKeySource{path: path, interval: interval}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileKeySource) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.lastCheck = time.Now()
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var certs Certs
+	if err = json.Unmarshal(data, &certs); err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.keys = newStaticKeySource(certs)
+	s.modTime = info.ModTime()
+	return nil
+}
+
+func (s *fileKeySource) GetKey(kid string) (KeyEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastCheck) >= s.interval {
+		// a broken file keeps the last good keys
+		_ = s.reload()
+	}
+	return s.keys.GetKey(kid)
+}
+
+// NewPEMKeySource serves PEM encoded public keys or certificates by key id
+func NewPEMKeySource(pemKeys map[string][]byte) (KeySource, error) {
+	certs := Certs{}
+	for kid, pemKey := range pemKeys {
+		block, _ := pem.Decode(pemKey)
+		if block == nil {
+			return nil, errors.New("No PEM data for kid " + kid)
+		}
+		var publicKey interface{}
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				publicKey = cert.PublicKey
+			}
+		case "RSA PUBLIC KEY":
+			publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("kid %s: %w", kid, err)
+		}
+		key, err := keyEntryFromPublicKey(kid, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		certs.Keys = append(certs.Keys, key)
+	}
+	return newStaticKeySource(certs), nil
+}
+
+func keyEntryFromPublicKey(kid string, publicKey interface{}) (KeyEntry, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return KeyEntry{
+			Kid: kid,
+			Kty: "RSA",
+			Alg: "RS256",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		crv := key.Curve.Params().Name
+		alg := map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}[crv]
+		return KeyEntry{
+			Kid: kid,
+			Kty: "EC",
+			Alg: alg,
+			Use: "sig",
+			Crv: crv,
+			X:   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+		}, nil
+	}
+	return KeyEntry{}, fmt.Errorf("kid %s: unsupported public key %T", kid, publicKey)
+}
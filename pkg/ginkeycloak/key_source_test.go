@@ -0,0 +1,87 @@
+package ginkeycloak
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// offlineConfig has no reachable Keycloak and no cached keys
+var offlineConfig = KeycloakConfig{Url: "http://127.0.0.1:1", Realm: "offline"}
+
+func Test_StaticKeySource(t *testing.T) {
+	jwks, _ := json.Marshal(Certs{Keys: testKeys})
+	source, err := NewStaticKeySource(jwks)
+	assert.NoError(t, err)
+	config := offlineConfig
+	config.KeySource = source
+
+	for _, token := range tokens {
+		ctx := buildContext(token)
+		Auth(AuthCheck(), config)(ctx)
+		assert.True(t, len(ctx.Errors) == 0)
+	}
+	_, err = source.GetKey("unknown")
+	assert.Error(t, err)
+}
+
+func Test_FileKeySource(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "jwks")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	jwks, _ := json.Marshal(Certs{Keys: testKeys[:1]})
+	assert.NoError(t, ioutil.WriteFile(path, jwks, 0600))
+
+	source, err := NewFileKeySource(path, 0)
+	assert.NoError(t, err)
+	_, err = source.GetKey("2")
+	assert.Error(t, err)
+
+	jwks, _ = json.Marshal(Certs{Keys: testKeys})
+	assert.NoError(t, ioutil.WriteFile(path, jwks, 0600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	key, err := source.GetKey("2")
+	assert.NoError(t, err)
+	assert.Equal(t, "EC", key.Kty)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("broken"), 0600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	_, err = source.GetKey("2")
+	assert.NoError(t, err, "the last good keys are kept")
+
+	_, err = NewFileKeySource(filepath.Join(dir, "missing.json"), time.Minute)
+	assert.Error(t, err)
+}
+
+func Test_PEMKeySource(t *testing.T) {
+	ecKey, _ := x509.MarshalPKIXPublicKey(dummyECKey.Public())
+	source, err := NewPEMKeySource(map[string][]byte{
+		"1": []byte(dummyPublicKey),
+		"2": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: ecKey}),
+	})
+	assert.NoError(t, err)
+	config := offlineConfig
+	config.KeySource = source
+
+	for _, token := range tokens {
+		ctx := buildContext(token)
+		Auth(AuthCheck(), config)(ctx)
+		assert.True(t, len(ctx.Errors) == 0)
+	}
+
+	_, err = NewPEMKeySource(map[string][]byte{"1": []byte("no pem")})
+	assert.Error(t, err)
+}
+
+func Test_offline_config_without_key_source(t *testing.T) {
+	ctx := buildContext(tokens[0])
+	Auth(AuthCheck(), offlineConfig)(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+}
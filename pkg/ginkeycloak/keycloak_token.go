@@ -12,6 +12,7 @@ type KeyCloakToken struct {
 	Nonce             string                 `json:"nonce,omitempty"`
 	AuthTime          int64                  `json:"auth_time,omitempty"`
 	SessionState      string                 `json:"session_state,omitempty"`
+	Sid               string                 `json:"sid,omitempty"`
 	Acr               string                 `json:"acr,omitempty"`
 	ClientSession     string                 `json:"client_session,omitempty"`
 	AllowedOrigins    []string               `json:"allowed-origins,omitempty"`
@@ -23,6 +24,13 @@ type KeyCloakToken struct {
 	Email             string                 `json:"email,omitempty"`
 	RealmAccess       ServiceRole            `json:"realm_access,omitempty"`
 	CustomClaims      interface{}            `json:"custom_claims,omitempty"`
+	Cnf               *Confirmation          `json:"cnf,omitempty"`
+}
+
+// Confirmation binds a token to a key of the client (RFC 7800)
+type Confirmation struct {
+	Jkt     string `json:"jkt,omitempty"`
+	X5tS256 string `json:"x5t#S256,omitempty"`
 }
 
 type ServiceRole struct {
@@ -0,0 +1,451 @@
+package ginkeycloak
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"golang.org/x/oauth2"
+)
+
+// SessionKey is the gin context key of the *Session set by Login.Auth
+const SessionKey = "session"
+
+// LoginStateMaxAge limits the time between redirecting to Keycloak and the callback
+var LoginStateMaxAge = 10 * time.Minute
+
+// LoginConfig configures the OIDC authorization code flow for server rendered gin apps
+type LoginConfig struct {
+	KeycloakConfig KeycloakConfig
+	ClientID       string
+	ClientSecret   string
+	// RedirectURL is the absolute URL the CallbackHandler is mounted at
+	RedirectURL string
+	// Scopes requested in addition to openid
+	Scopes []string
+	// CookieSecret encrypts the session cookie, 16, 24 or 32 bytes for AES-128, AES-192 or AES-256
+	CookieSecret []byte
+	CookieName   string
+	CookiePath   string
+	CookieDomain string
+	// InsecureCookie allows the cookies to be sent over http, only for local development
+	InsecureCookie bool
+	// LoginPath is where the LoginHandler is mounted, unauthenticated browsers are redirected to it
+	LoginPath string
+	// DefaultRedirect is the target after login if the login did not start from a protected page
+	DefaultRedirect string
+	// PostLogoutRedirect is the target after logout, Keycloak requires an absolute URL registered
+	// as valid post logout redirect URI of the client. If empty Keycloak shows its logout page.
+	PostLogoutRedirect string
+	// RefreshBefore is how long before expiry Refresh renews the access token, defaults to DefaultRefreshBefore
+	RefreshBefore time.Duration
+	// SessionStore keeps the tokens server side, the cookie then only holds the session id.
+	// Without store the tokens are kept in the encrypted cookie.
+	SessionStore SessionStore
+	// SessionTTL is how long sessions are kept in the SessionStore, defaults to DefaultSessionTTL
+	SessionTTL time.Duration
+}
+
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to"`
+}
+
+// Login provides login, callback and logout handlers and a middleware for browser sessions
+type Login struct {
+	config       LoginConfig
+	oauth2Config *oauth2.Config
+	codec        *cookieCodec
+	refresher    *sessionRefresher
+}
+
+func NewLogin(config LoginConfig) (*Login, error) {
+	if config.KeycloakConfig.JtiStore != nil {
+		return nil, errors.New("JtiStore can not be used with sessions, the session token is sent with every request")
+	}
+	codec, err := newCookieCodec(config.CookieSecret)
+	if err != nil {
+		return nil, err
+	}
+	if config.CookieName == "" {
+		config.CookieName = "KEYCLOAK_SESSION"
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = "/"
+	}
+	if config.LoginPath == "" {
+		config.LoginPath = "/login"
+	}
+	if config.DefaultRedirect == "" {
+		config.DefaultRedirect = "/"
+	}
+	if config.PostLogoutRedirect != "" {
+		if u, err := url.Parse(config.PostLogoutRedirect); err != nil || !u.IsAbs() {
+			return nil, errors.New("PostLogoutRedirect must be an absolute URL")
+		}
+	}
+	if config.SessionTTL == 0 {
+		config.SessionTTL = DefaultSessionTTL
+	}
+	config.KeycloakConfig.tokenCacheScope = newTokenCacheScope()
+
+	authURL, err := realmURL(config.KeycloakConfig, "protocol/openid-connect/auth")
+	if err != nil {
+		return nil, err
+	}
+	tokenURL, err := realmURL(config.KeycloakConfig, "protocol/openid-connect/token")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Login{
+		config: config,
+		oauth2Config: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Scopes:       append([]string{"openid"}, config.Scopes...),
+			Endpoint:     oauth2.Endpoint{AuthURL: authURL, TokenURL: tokenURL},
+		},
+		codec:     codec,
+		refresher: newSessionRefresher(),
+	}, nil
+}
+
+func (l *Login) stateCookieName() string {
+	return l.config.CookieName + "_STATE"
+}
+
+func (l *Login) setCookie(ctx *gin.Context, name string, value string, maxAge int) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     l.config.CookiePath,
+		Domain:   l.config.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   !l.config.InsecureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (l *Login) httpContext(ctx *gin.Context) context.Context {
+	if l.config.KeycloakConfig.HTTPClient != nil {
+		return context.WithValue(ctx.Request.Context(), oauth2.HTTPClient, l.config.KeycloakConfig.HTTPClient)
+	}
+	return ctx.Request.Context()
+}
+
+// LoginHandler redirects to Keycloak, the optional return_to query parameter is the local
+// path to return to after login
+func (l *Login) LoginHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		state, err := newLoginState(safeReturnTo(ctx.Query("return_to"), l.config.DefaultRedirect))
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		encoded, err := l.codec.encode(l.stateCookieName(), state)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		l.setCookie(ctx, l.stateCookieName(), encoded, int(LoginStateMaxAge/time.Second))
+
+		authCodeURL := l.oauth2Config.AuthCodeURL(state.State,
+			oauth2.S256ChallengeOption(state.Verifier),
+			oauth2.SetAuthURLParam("nonce", state.Nonce))
+		ctx.Redirect(http.StatusFound, authCodeURL)
+	}
+}
+
+func newLoginState(returnTo string) (*loginState, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	return &loginState{State: state, Nonce: nonce, Verifier: oauth2.GenerateVerifier(), ReturnTo: returnTo}, nil
+}
+
+// safeReturnTo only allows local paths to prevent open redirects. Browsers ignore tabs and newlines
+// and treat backslashes as slashes, so "/\t/evil.com" would still lead to another host
+func safeReturnTo(returnTo string, fallback string) string {
+	if strings.ContainsRune(returnTo, '\\') || strings.IndexFunc(returnTo, unicode.IsControl) >= 0 {
+		return fallback
+	}
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(returnTo, "//") {
+		return fallback
+	}
+	return returnTo
+}
+
+// CallbackHandler exchanges the authorization code, validates state and nonce and starts the session
+func (l *Login) CallbackHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var state loginState
+		cookie, err := ctx.Request.Cookie(l.stateCookieName())
+		if err != nil || l.codec.decode(l.stateCookieName(), cookie.Value, &state) != nil {
+			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("No login in progress"))
+			return
+		}
+		l.setCookie(ctx, l.stateCookieName(), "", -1)
+
+		if errorCode := ctx.Query("error"); errorCode != "" {
+			_ = ctx.AbortWithError(http.StatusUnauthorized, errors.New("Login failed: "+errorCode))
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(ctx.Query("state")), []byte(state.State)) != 1 {
+			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("Invalid state"))
+			return
+		}
+
+		token, err := l.oauth2Config.Exchange(l.httpContext(ctx), ctx.Query("code"), oauth2.VerifierOption(state.Verifier))
+		if err != nil {
+			glog.Errorf("[Gin-OAuth] Code exchange failed: %s", err)
+			_ = ctx.AbortWithError(http.StatusUnauthorized, err)
+			return
+		}
+		rawIDToken, _ := token.Extra("id_token").(string)
+		idToken, err := VerifyIDToken(rawIDToken, l.config.KeycloakConfig, IDTokenVerification{
+			ClientID:    l.config.ClientID,
+			Nonce:       state.Nonce,
+			AccessToken: token.AccessToken,
+		})
+		if err != nil {
+			glog.Errorf("[Gin-OAuth] Invalid id token: %s", err)
+			_ = ctx.AbortWithError(http.StatusUnauthorized, err)
+			return
+		}
+
+		sessionID, err := randomString()
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		session := &Session{
+			ID:           sessionID,
+			AccessToken:  token.AccessToken,
+			RefreshToken: token.RefreshToken,
+			IDToken:      rawIDToken,
+			Expiry:       token.Expiry,
+			Sid:          idToken.Sid,
+		}
+		if err = l.saveSession(ctx, session); err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		ctx.Redirect(http.StatusFound, state.ReturnTo)
+	}
+}
+
+// LogoutHandler ends the local session and the Keycloak SSO session by redirecting to the
+// end_session_endpoint of the realm (RP-initiated logout)
+func (l *Login) LogoutHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session, _ := l.loadSession(ctx)
+		l.clearSession(ctx)
+
+		metadata, err := GetRealmMetadata(l.config.KeycloakConfig)
+		if err != nil || metadata.EndSessionEndpoint == "" {
+			glog.Errorf("[Gin-OAuth] No end_session_endpoint, only the local session is ended: %v", err)
+			redirect := l.config.PostLogoutRedirect
+			if redirect == "" {
+				redirect = "/"
+			}
+			ctx.Redirect(http.StatusFound, redirect)
+			return
+		}
+		params := url.Values{"client_id": {l.config.ClientID}}
+		if l.config.PostLogoutRedirect != "" {
+			params.Set("post_logout_redirect_uri", l.config.PostLogoutRedirect)
+		}
+		if session != nil && session.IDToken != "" {
+			params.Set("id_token_hint", session.IDToken)
+		}
+		ctx.Redirect(http.StatusFound, metadata.EndSessionEndpoint+"?"+params.Encode())
+	}
+}
+
+// FrontChannelLogoutHandler handles OpenID Connect front-channel logout requests, Keycloak loads
+// it in an iframe with the iss and sid of the ended SSO session. The request is unauthenticated,
+// so only the session of the browser is ended, server wide revocation by sid is left to the
+// signed back-channel logout. Unless Keycloak and the app share a site the iframe is cross-site,
+// browsers then do not send the SameSite=Lax session cookie and nothing is ended.
+func (l *Login) FrontChannelLogoutHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("Cache-Control", "no-store")
+		iss, sid := ctx.Query("iss"), ctx.Query("sid")
+		if sid != "" && iss == "" {
+			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("sid requires iss"))
+			return
+		}
+		if iss != "" {
+			metadata, err := GetRealmMetadata(l.config.KeycloakConfig)
+			if err != nil {
+				_ = ctx.AbortWithError(http.StatusServiceUnavailable, err)
+				return
+			}
+			if iss != metadata.Issuer {
+				_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("Unknown issuer "+iss))
+				return
+			}
+		}
+
+		session, err := l.loadSession(ctx)
+		if err != nil || (sid != "" && session.Sid != sid) {
+			ctx.Status(http.StatusOK)
+			return
+		}
+		l.clearSession(ctx)
+		ctx.Status(http.StatusOK)
+	}
+}
+
+func (l *Login) loadSession(ctx *gin.Context) (*Session, error) {
+	if session, ok := ctx.Get(SessionKey); ok {
+		return session.(*Session), nil
+	}
+	cookie, err := ctx.Request.Cookie(l.config.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, ErrNoSession
+	}
+	if l.config.SessionStore != nil {
+		return l.config.SessionStore.Get(cookie.Value)
+	}
+	var session Session
+	if err = l.codec.decode(l.config.CookieName, cookie.Value, &session); err != nil {
+		return nil, ErrNoSession
+	}
+	return &session, nil
+}
+
+func (l *Login) saveSession(ctx *gin.Context, session *Session) error {
+	if l.config.SessionStore != nil {
+		if err := l.config.SessionStore.Save(session, l.config.SessionTTL); err != nil {
+			return err
+		}
+		l.setCookie(ctx, l.config.CookieName, session.ID, 0)
+		ctx.Set(SessionKey, session)
+		return nil
+	}
+	encoded, err := l.codec.encode(l.config.CookieName, session)
+	if err != nil {
+		return err
+	}
+	if len(encoded) > 4000 {
+		glog.Warningf("[Gin-OAuth] session cookie has %d bytes and may be rejected by browsers, use a SessionStore", len(encoded))
+	}
+	l.setCookie(ctx, l.config.CookieName, encoded, 0)
+	ctx.Set(SessionKey, session)
+	return nil
+}
+
+func (l *Login) clearSession(ctx *gin.Context) {
+	if l.config.SessionStore != nil {
+		if session, err := l.loadSession(ctx); err == nil {
+			if err = l.config.SessionStore.Delete(session.ID); err != nil {
+				glog.Errorf("[Gin-OAuth] Can not delete session, caused by: %s", err)
+			}
+		}
+	}
+	delete(ctx.Keys, SessionKey)
+	l.setCookie(ctx, l.config.CookieName, "", -1)
+}
+
+// BackchannelLogoutHandler handles OpenID Connect back-channel logout requests and deletes the
+// sessions of the logged out Keycloak session from the SessionStore
+func (l *Login) BackchannelLogoutHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims, ok := parseLogoutToken(ctx, l.config.KeycloakConfig, l.config.ClientID)
+		if !ok {
+			return
+		}
+		if l.config.KeycloakConfig.Revocations != nil {
+			revoke(claims, l.config.KeycloakConfig.Revocations)
+		}
+		if claims.Sid != "" && l.config.SessionStore != nil {
+			if err := l.config.SessionStore.DeleteBySid(claims.Sid); err != nil {
+				_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+		}
+		ctx.Status(http.StatusOK)
+	}
+}
+
+// Auth protects routes of browser apps: unauthenticated browsers are redirected to the login,
+// sessions are verified like bearer tokens and the access check functions are applied
+func (l *Login) Auth(accessCheckFunctions ...AccessCheckFunction) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session, err := l.loadSession(ctx)
+		if err != nil {
+			l.unauthenticated(ctx, err)
+			return
+		}
+		tokenContainer, err := verifyToken(ctx, session.Token(), l.config.KeycloakConfig)
+		if err != nil {
+			l.clearSession(ctx)
+			l.unauthenticated(ctx, err)
+			return
+		}
+		ctx.Set(SessionKey, session)
+		if csrf := l.config.KeycloakConfig.CSRF; csrf != nil {
+			if err = csrf.protect(ctx, tokenContainer.KeyCloakToken.Sub); err != nil {
+				glog.Errorf("[Gin-OAuth] %s", err)
+				_ = ctx.AbortWithError(http.StatusForbidden, err)
+				return
+			}
+		}
+		if !checkAccess(tokenContainer, ctx, l.config.KeycloakConfig, accessCheckFunctions) {
+			glog.V(2).Infof("[Gin-OAuth] %s access not allowed", ctx.Request.URL.Path)
+			return
+		}
+		withTokenContainer(ctx, tokenContainer)
+	}
+}
+
+// CSRFProtection creates a CSRF protection with tokens bound to the session and applies it in
+// Auth. Call it during setup, before requests are served.
+func (l *Login) CSRFProtection(config CSRFConfig) (*CSRFProtection, error) {
+	config.SessionID = l.SessionID
+	csrf, err := NewCSRFProtection(config)
+	if err != nil {
+		return nil, err
+	}
+	l.config.KeycloakConfig.CSRF = csrf
+	return csrf, nil
+}
+
+// SessionID returns the id of the current session
+func (l *Login) SessionID(ctx *gin.Context) (string, bool) {
+	session, err := l.loadSession(ctx)
+	if err != nil {
+		return "", false
+	}
+	return session.ID, true
+}
+
+// unauthenticated redirects page navigations to the login and rejects other requests with 401
+func (l *Login) unauthenticated(ctx *gin.Context, err error) {
+	r := ctx.Request
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		ctx.Redirect(http.StatusFound, l.config.LoginPath+"?"+url.Values{"return_to": {r.URL.RequestURI()}}.Encode())
+		ctx.Abort()
+		return
+	}
+	_ = ctx.AbortWithError(http.StatusUnauthorized, err)
+}
@@ -0,0 +1,317 @@
+package ginkeycloak
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const loginClientID = "web-app"
+
+type fakeKeycloak struct {
+	server        *httptest.Server
+	nonce         string
+	sid           string
+	mu            sync.Mutex
+	refreshCalls  int
+	refreshFailed bool
+	userInfoCalls int
+}
+
+func newFakeKeycloak() *fakeKeycloak {
+	kc := &fakeKeycloak{sid: "keycloak-session"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/test/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("code_verifier") == "" && r.PostForm.Get("grant_type") == "authorization_code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.PostForm.Get("grant_type") == "refresh_token" {
+			kc.mu.Lock()
+			kc.refreshCalls++
+			failed := kc.refreshFailed
+			kc.mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			if failed {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(kc.tokenResponse())
+	})
+	mux.HandleFunc("/realms/test/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(RealmMetadata{
+			Issuer:             kc.issuer(),
+			TokenEndpoint:      kc.issuer() + "/protocol/openid-connect/token",
+			EndSessionEndpoint: kc.issuer() + "/protocol/openid-connect/logout",
+			UserinfoEndpoint:   kc.issuer() + "/protocol/openid-connect/userinfo",
+		})
+	})
+	mux.HandleFunc("/realms/test/protocol/openid-connect/certs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Certs{Keys: testKeys})
+	})
+	mux.HandleFunc("/realms/test/protocol/openid-connect/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		kc.mu.Lock()
+		kc.userInfoCalls++
+		kc.mu.Unlock()
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub":        "user",
+			"name":       "Jane Doe",
+			"email":      "jane@example.com",
+			"department": "sales",
+		})
+	})
+	kc.server = httptest.NewServer(mux)
+	return kc
+}
+
+func (kc *fakeKeycloak) issuer() string {
+	return kc.server.URL + "/realms/test"
+}
+
+func (kc *fakeKeycloak) tokenResponse() map[string]interface{} {
+	accessToken := createToken(time.Now().Add(time.Minute))
+	accessToken.Iss = kc.issuer()
+	accessToken.Sid = kc.sid
+	idToken := map[string]interface{}{
+		"iss":   kc.issuer(),
+		"sub":   "user",
+		"aud":   loginClientID,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": kc.nonce,
+		"sid":   kc.sid,
+		"typ":   "ID",
+	}
+	return map[string]interface{}{
+		"access_token":  signRSAToken(accessToken),
+		"refresh_token": "refresh-" + kc.sid,
+		"id_token":      signRSAToken(idToken),
+		"token_type":    "Bearer",
+		"expires_in":    60,
+	}
+}
+
+func newTestLogin(t *testing.T, kc *fakeKeycloak, options ...func(config *LoginConfig)) (*Login, *gin.Engine) {
+	config := LoginConfig{
+		KeycloakConfig: KeycloakConfig{Url: kc.server.URL, Realm: "test"},
+		ClientID:       loginClientID,
+		ClientSecret:   "secret",
+		RedirectURL:    "http://app.example.com/callback",
+		CookieSecret:   []byte("0123456789abcdef0123456789abcdef"),
+	}
+	for _, option := range options {
+		option(&config)
+	}
+	login, err := NewLogin(config)
+	assert.NoError(t, err)
+
+	router := gin.New()
+	router.GET("/login", login.LoginHandler())
+	router.GET("/callback", login.CallbackHandler())
+	router.GET("/logout", login.LogoutHandler())
+	router.GET("/private", login.Refresh(), login.Auth(AuthCheck()), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return login, router
+}
+
+func serve(router *gin.Engine, target string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Accept", "text/html")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func responseCookie(resp *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// loginFlow runs login and callback and returns the session cookie
+func loginFlow(t *testing.T, kc *fakeKeycloak, router *gin.Engine) *http.Cookie {
+	resp := serve(router, "/login?return_to=/private", nil)
+	assert.Equal(t, http.StatusFound, resp.Code)
+	authURL, _ := url.Parse(resp.Header().Get("Location"))
+	assert.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+	kc.nonce = authURL.Query().Get("nonce")
+	stateCookie := responseCookie(resp, "KEYCLOAK_SESSION_STATE")
+
+	resp = serve(router, "/callback?code=abc&state="+authURL.Query().Get("state"), []*http.Cookie{stateCookie})
+	assert.Equal(t, http.StatusFound, resp.Code)
+	assert.Equal(t, "/private", resp.Header().Get("Location"))
+	return responseCookie(resp, "KEYCLOAK_SESSION")
+}
+
+func Test_Login_flow(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	_, router := newTestLogin(t, kc)
+
+	sessionCookie := loginFlow(t, kc, router)
+	assert.NotNil(t, sessionCookie)
+
+	resp := serve(router, "/private", []*http.Cookie{sessionCookie})
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func Test_Login_redirects_unauthenticated(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	_, router := newTestLogin(t, kc)
+
+	resp := serve(router, "/private", nil)
+
+	assert.Equal(t, http.StatusFound, resp.Code)
+	assert.Equal(t, "/login?return_to=%2Fprivate", resp.Header().Get("Location"))
+}
+
+func Test_Login_rejects_wrong_state(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	_, router := newTestLogin(t, kc)
+
+	resp := serve(router, "/login", nil)
+	stateCookie := responseCookie(resp, "KEYCLOAK_SESSION_STATE")
+	resp = serve(router, "/callback?code=abc&state=forged", []*http.Cookie{stateCookie})
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Nil(t, responseCookie(resp, "KEYCLOAK_SESSION"))
+}
+
+func Test_Login_rejects_wrong_nonce(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	_, router := newTestLogin(t, kc)
+
+	resp := serve(router, "/login", nil)
+	authURL, _ := url.Parse(resp.Header().Get("Location"))
+	kc.nonce = "replayed"
+	resp = serve(router, "/callback?code=abc&state="+authURL.Query().Get("state"),
+		[]*http.Cookie{responseCookie(resp, "KEYCLOAK_SESSION_STATE")})
+
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func Test_Login_safe_return_to(t *testing.T) {
+	assert.Equal(t, "/private?a=b", safeReturnTo("/private?a=b", "/"))
+	assert.Equal(t, "/", safeReturnTo("https://evil.example.com", "/"))
+	assert.Equal(t, "/", safeReturnTo("//evil.example.com", "/"))
+	assert.Equal(t, "/", safeReturnTo("/\\evil.example.com", "/"))
+	assert.Equal(t, "/", safeReturnTo("/\t/evil.example.com", "/"))
+	assert.Equal(t, "/", safeReturnTo("/\n/evil.example.com", "/"))
+	assert.Equal(t, "/", safeReturnTo("private", "/"))
+}
+
+func Test_Login_tab_encoded_return_to(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	_, router := newTestLogin(t, kc)
+
+	resp := serve(router, "/login?return_to=%2F%09%2Fevil.com", nil)
+	authURL, _ := url.Parse(resp.Header().Get("Location"))
+	kc.nonce = authURL.Query().Get("nonce")
+	stateCookie := responseCookie(resp, "KEYCLOAK_SESSION_STATE")
+
+	resp = serve(router, "/callback?code=abc&state="+authURL.Query().Get("state"), []*http.Cookie{stateCookie})
+	assert.Equal(t, http.StatusFound, resp.Code)
+	assert.Equal(t, "/", resp.Header().Get("Location"))
+}
+
+func Test_Logout_ends_sso_session(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	_, router := newTestLogin(t, kc, func(config *LoginConfig) {
+		config.PostLogoutRedirect = "http://app.example.com/"
+	})
+	sessionCookie := loginFlow(t, kc, router)
+
+	resp := serve(router, "/logout", []*http.Cookie{sessionCookie})
+
+	assert.Equal(t, http.StatusFound, resp.Code)
+	location, _ := url.Parse(resp.Header().Get("Location"))
+	assert.Equal(t, "/realms/test/protocol/openid-connect/logout", location.Path)
+	assert.NotEmpty(t, location.Query().Get("id_token_hint"))
+	assert.Equal(t, "http://app.example.com/", location.Query().Get("post_logout_redirect_uri"))
+	assert.Equal(t, loginClientID, location.Query().Get("client_id"))
+	assert.Equal(t, -1, responseCookie(resp, "KEYCLOAK_SESSION").MaxAge)
+}
+
+func Test_Logout_without_post_logout_redirect(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	_, router := newTestLogin(t, kc)
+	sessionCookie := loginFlow(t, kc, router)
+
+	resp := serve(router, "/logout", []*http.Cookie{sessionCookie})
+
+	location, _ := url.Parse(resp.Header().Get("Location"))
+	assert.Equal(t, "/realms/test/protocol/openid-connect/logout", location.Path)
+	_, ok := location.Query()["post_logout_redirect_uri"]
+	assert.False(t, ok)
+}
+
+func Test_Logout_relative_post_logout_redirect_refused(t *testing.T) {
+	_, err := NewLogin(LoginConfig{
+		KeycloakConfig:     KeycloakConfig{Url: "http://keycloak", Realm: "test"},
+		CookieSecret:       []byte("0123456789abcdef"),
+		PostLogoutRedirect: "/",
+	})
+	assert.Error(t, err)
+}
+
+func Test_FrontChannelLogout(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	revocations := NewRevocationStore()
+	login, router := newTestLogin(t, kc, func(config *LoginConfig) {
+		config.SessionStore = NewMemorySessionStore(time.Minute)
+		config.KeycloakConfig.Revocations = revocations
+	})
+	router.GET("/frontchannel-logout", login.FrontChannelLogoutHandler())
+	sessionCookie := loginFlow(t, kc, router)
+
+	resp := serve(router, "/frontchannel-logout?"+url.Values{"iss": {"https://evil.example.com"}, "sid": {kc.sid}}.Encode(), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, http.StatusOK, serve(router, "/private", []*http.Cookie{sessionCookie}).Code)
+
+	resp = serve(router, "/frontchannel-logout?"+url.Values{"sid": {kc.sid}}.Encode(), []*http.Cookie{sessionCookie})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, http.StatusOK, serve(router, "/private", []*http.Cookie{sessionCookie}).Code)
+
+	// without the session cookie of the sid nothing is ended
+	resp = serve(router, "/frontchannel-logout?"+url.Values{"iss": {kc.issuer()}, "sid": {kc.sid}}.Encode(), nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, http.StatusOK, serve(router, "/private", []*http.Cookie{sessionCookie}).Code)
+
+	resp = serve(router, "/frontchannel-logout?"+url.Values{"iss": {kc.issuer()}, "sid": {kc.sid}}.Encode(), []*http.Cookie{sessionCookie})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, http.StatusFound, serve(router, "/private", []*http.Cookie{sessionCookie}).Code)
+	assert.False(t, revocations.IsSessionRevoked(kc.sid), "server wide revocation is left to back-channel logout")
+}
@@ -0,0 +1,102 @@
+package ginkeycloak
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache is a bounded least recently used cache with per entry expiry
+type lruCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type lruEntry struct {
+	key    string
+	value  interface{}
+	expiry time.Time
+}
+
+func newLRUCache(maxEntries int) *lruCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expiry) {
+		c.removeElement(element)
+		return nil, false
+	}
+	c.ll.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *lruCache) set(key string, value interface{}, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(key, value, expiry)
+}
+
+// add stores the value only if the key is not present yet and reports whether it was stored
+func (c *lruCache) add(key string, value interface{}, expiry time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.items[key]; ok && !time.Now().After(element.Value.(*lruEntry).expiry) {
+		return false
+	}
+	c.setLocked(key, value, expiry)
+	return true
+}
+
+func (c *lruCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+func (c *lruCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+}
+
+func (c *lruCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *lruCache) setLocked(key string, value interface{}, expiry time.Time) {
+	if element, ok := c.items[key]; ok {
+		c.ll.MoveToFront(element)
+		entry := element.Value.(*lruEntry)
+		entry.value = value
+		entry.expiry = expiry
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expiry: expiry})
+	for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *lruCache) removeElement(element *list.Element) {
+	c.ll.Remove(element)
+	delete(c.items, element.Value.(*lruEntry).key)
+}
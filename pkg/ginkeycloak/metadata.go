@@ -0,0 +1,78 @@
+package ginkeycloak
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// MaxResponseSize limits the size of discovery, userinfo and token endpoint responses
+var MaxResponseSize int64 = 1 << 20
+
+var realmMetadataCache = cache.New(time.Hour, time.Hour)
+
+// RealmMetadata is the OpenID Connect discovery document of a realm
+type RealmMetadata struct {
+	Issuer                             string `json:"issuer"`
+	AuthorizationEndpoint              string `json:"authorization_endpoint"`
+	TokenEndpoint                      string `json:"token_endpoint"`
+	UserinfoEndpoint                   string `json:"userinfo_endpoint"`
+	EndSessionEndpoint                 string `json:"end_session_endpoint"`
+	JwksURI                            string `json:"jwks_uri"`
+	FrontchannelLogoutSupported        bool   `json:"frontchannel_logout_supported"`
+	FrontchannelLogoutSessionSupported bool   `json:"frontchannel_logout_session_supported"`
+	BackchannelLogoutSupported         bool   `json:"backchannel_logout_supported"`
+	BackchannelLogoutSessionSupported  bool   `json:"backchannel_logout_session_supported"`
+}
+
+// GetRealmMetadata returns the discovery document of the realm from cache or .well-known/openid-configuration
+func GetRealmMetadata(config KeycloakConfig) (*RealmMetadata, error) {
+	u, err := realmURL(config, ".well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	if entry, exists := realmMetadataCache.Get(u); exists {
+		return entry.(*RealmMetadata), nil
+	}
+
+	httpClient := http.DefaultClient
+	if config.HTTPClient != nil {
+		httpClient = config.HTTPClient
+	}
+	resp, err := httpClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %d", u, resp.StatusCode)
+	}
+
+	body, err := readResponse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", u, err)
+	}
+	var metadata RealmMetadata
+	if err = json.Unmarshal(body, &metadata); err != nil {
+		return nil, err
+	}
+	realmMetadataCache.Set(u, &metadata, cache.DefaultExpiration)
+	return &metadata, nil
+}
+
+// readResponse reads a response body of at most MaxResponseSize bytes
+func readResponse(body io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, MaxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxResponseSize {
+		return nil, fmt.Errorf("response exceeds %d bytes", MaxResponseSize)
+	}
+	return data, nil
+}
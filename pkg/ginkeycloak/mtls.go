@@ -0,0 +1,70 @@
+package ginkeycloak
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrCertificateMismatch = errors.New("Token is not bound to the client certificate")
+
+// validateCertificateBinding checks the cnf.x5t#S256 claim of certificate bound tokens (RFC 8705)
+func validateCertificateBinding(r *http.Request, keyCloakToken *KeyCloakToken, config KeycloakConfig) error {
+	thumbprint := ""
+	if keyCloakToken.Cnf != nil {
+		thumbprint = keyCloakToken.Cnf.X5tS256
+	}
+	if thumbprint == "" {
+		if config.RequireCertificateBoundTokens {
+			return fmt.Errorf("%w: token has no cnf.x5t#S256 claim", ErrCertificateMismatch)
+		}
+		return nil
+	}
+
+	cert, err := clientCertificate(r, config)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCertificateMismatch, err)
+	}
+	sum := sha256.Sum256(cert.Raw)
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(thumbprint)) != 1 {
+		return fmt.Errorf("%w: thumbprint mismatch", ErrCertificateMismatch)
+	}
+	return nil
+}
+
+// clientCertificate returns the TLS peer certificate, or the certificate forwarded by a trusted
+// proxy in KeycloakConfig.ClientCertHeader (URL encoded PEM or base64 DER)
+func clientCertificate(r *http.Request, config KeycloakConfig) (*x509.Certificate, error) {
+	if config.ClientCertHeader == "" {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return nil, errors.New("no client certificate presented")
+		}
+		return r.TLS.PeerCertificates[0], nil
+	}
+
+	value := r.Header.Get(config.ClientCertHeader)
+	if value == "" {
+		return nil, errors.New("no client certificate in header " + config.ClientCertHeader)
+	}
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		value = unescaped
+	}
+	var der []byte
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		der = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, errors.New("client certificate header is neither PEM nor base64 DER")
+		}
+		der = decoded
+	}
+	return x509.ParseCertificate(der)
+}
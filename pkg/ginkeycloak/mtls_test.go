@@ -0,0 +1,82 @@
+package ginkeycloak
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func selfSignedCertificate(cn string) *x509.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func certificateBoundToken(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	token := createToken(time.Now().Add(time.Minute))
+	token.Cnf = &Confirmation{X5tS256: base64.RawURLEncoding.EncodeToString(sum[:])}
+	return signRSAToken(token)
+}
+
+func Test_MTLS_peer_certificate(t *testing.T) {
+	cert := selfSignedCertificate("client")
+	authFunc := Auth(AuthCheck(), KeycloakConfig{})
+
+	ctx := buildContext(certificateBoundToken(cert))
+	ctx.Request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+
+	ctx = buildContext(certificateBoundToken(cert))
+	ctx.Request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{selfSignedCertificate("other")}}
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrCertificateMismatch))
+}
+
+func Test_MTLS_forwarded_certificate(t *testing.T) {
+	cert := selfSignedCertificate("client")
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	authFunc := Auth(AuthCheck(), KeycloakConfig{ClientCertHeader: "X-Client-Cert"})
+
+	ctx := buildContext(certificateBoundToken(cert))
+	ctx.Request.Header.Set("X-Client-Cert", url.PathEscape(string(pemCert)))
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+
+	ctx = buildContext(certificateBoundToken(cert))
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrCertificateMismatch))
+}
+
+func Test_MTLS_required(t *testing.T) {
+	authFunc := Auth(AuthCheck(), KeycloakConfig{RequireCertificateBoundTokens: true})
+
+	ctx := buildContext(tokens[0])
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrCertificateMismatch))
+}
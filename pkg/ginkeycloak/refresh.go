@@ -0,0 +1,117 @@
+package ginkeycloak
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/oauth2"
+)
+
+// DefaultRefreshBefore is used if LoginConfig.RefreshBefore is not set
+var DefaultRefreshBefore = 30 * time.Second
+
+type refreshCall struct {
+	wg      sync.WaitGroup
+	session *Session
+	err     error
+}
+
+// sessionRefresher runs at most one refresh per session at a time. Refreshed sessions are
+// remembered for a while, so requests still carrying the old cookie do not reuse a rotated
+// refresh token.
+type sessionRefresher struct {
+	mu     sync.Mutex
+	calls  map[string]*refreshCall
+	recent *cache.Cache
+}
+
+func newSessionRefresher() *sessionRefresher {
+	return &sessionRefresher{
+		calls:  map[string]*refreshCall{},
+		recent: cache.New(time.Minute, 5*time.Minute),
+	}
+}
+
+func (r *sessionRefresher) refresh(session *Session, fn func() (*Session, error)) (*Session, error) {
+	if cached, ok := r.recent.Get(session.ID); ok && cached.(*Session).Expiry.After(session.Expiry) {
+		return cached.(*Session), nil
+	}
+
+	r.mu.Lock()
+	if call, ok := r.calls[session.ID]; ok {
+		r.mu.Unlock()
+		call.wg.Wait()
+		return call.session, call.err
+	}
+	call := &refreshCall{}
+	call.wg.Add(1)
+	r.calls[session.ID] = call
+	r.mu.Unlock()
+
+	call.session, call.err = fn()
+	if call.err == nil {
+		r.recent.Set(session.ID, call.session, cache.DefaultExpiration)
+	}
+
+	r.mu.Lock()
+	delete(r.calls, session.ID)
+	r.mu.Unlock()
+	call.wg.Done()
+	return call.session, call.err
+}
+
+func (l *Login) needsRefresh(session *Session) bool {
+	refreshBefore := l.config.RefreshBefore
+	if refreshBefore == 0 {
+		refreshBefore = DefaultRefreshBefore
+	}
+	return session.RefreshToken != "" && time.Until(session.Expiry) < refreshBefore
+}
+
+func (l *Login) refreshSession(ctx *gin.Context, session *Session) (*Session, error) {
+	expired := &oauth2.Token{RefreshToken: session.RefreshToken, Expiry: time.Now().Add(-time.Second)}
+	token, err := l.oauth2Config.TokenSource(l.httpContext(ctx), expired).Token()
+	if err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("No access token in refresh response")
+	}
+	refreshed := *session
+	refreshed.AccessToken = token.AccessToken
+	refreshed.RefreshToken = token.RefreshToken
+	refreshed.Expiry = token.Expiry
+	if rawIDToken, ok := token.Extra("id_token").(string); ok && rawIDToken != "" {
+		refreshed.IDToken = rawIDToken
+	}
+	return &refreshed, nil
+}
+
+// Refresh renews the access token of the session with its refresh token shortly before it
+// expires. Use it in front of Login.Auth. If the refresh fails, the session is ended and the
+// browser is sent to the login again.
+func (l *Login) Refresh() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session, err := l.loadSession(ctx)
+		if err != nil || !l.needsRefresh(session) {
+			return
+		}
+		refreshed, err := l.refresher.refresh(session, func() (*Session, error) {
+			return l.refreshSession(ctx, session)
+		})
+		if err != nil {
+			glog.Errorf("[Gin-OAuth] Token refresh failed, caused by: %s", err)
+			l.clearSession(ctx)
+			l.unauthenticated(ctx, err)
+			return
+		}
+		if err = l.saveSession(ctx, refreshed); err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+		}
+	}
+}
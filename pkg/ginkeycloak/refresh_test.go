@@ -0,0 +1,68 @@
+package ginkeycloak
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func refreshSoon(config *LoginConfig) {
+	config.RefreshBefore = 2 * time.Minute
+}
+
+func Test_Refresh_concurrent_requests_refresh_once(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	_, router := newTestLogin(t, kc, refreshSoon)
+	sessionCookie := loginFlow(t, kc, router)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 5)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = serve(router, "/private", []*http.Cookie{sessionCookie}).Code
+		}(i)
+	}
+	wg.Wait()
+
+	for _, code := range codes {
+		assert.Equal(t, http.StatusOK, code)
+	}
+	assert.Equal(t, 1, kc.refreshCalls)
+
+	resp := serve(router, "/private", []*http.Cookie{sessionCookie})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotNil(t, responseCookie(resp, "KEYCLOAK_SESSION"))
+	assert.Equal(t, 1, kc.refreshCalls)
+}
+
+func Test_Refresh_not_needed(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	_, router := newTestLogin(t, kc)
+	sessionCookie := loginFlow(t, kc, router)
+
+	resp := serve(router, "/private", []*http.Cookie{sessionCookie})
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, 0, kc.refreshCalls)
+}
+
+func Test_Refresh_failure_forces_login(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	_, router := newTestLogin(t, kc, refreshSoon)
+	sessionCookie := loginFlow(t, kc, router)
+	kc.refreshFailed = true
+
+	resp := serve(router, "/private", []*http.Cookie{sessionCookie})
+
+	assert.Equal(t, http.StatusFound, resp.Code)
+	assert.Equal(t, "/login?return_to=%2Fprivate", resp.Header().Get("Location"))
+	assert.Equal(t, -1, responseCookie(resp, "KEYCLOAK_SESSION").MaxAge)
+}
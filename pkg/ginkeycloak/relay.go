@@ -0,0 +1,97 @@
+package ginkeycloak
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+)
+
+type tokenContainerKey struct{}
+
+func withTokenContainer(ctx *gin.Context, tokenContainer *TokenContainer) {
+	ctx.Request = ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), tokenContainerKey{}, tokenContainer))
+}
+
+// TokenContainerFromContext returns the verified token of the request. Pass the request context
+// (or the gin.Context) of a request that passed the auth middleware.
+func TokenContainerFromContext(ctx context.Context) (*TokenContainer, bool) {
+	if ginCtx, ok := ctx.(*gin.Context); ok {
+		if ginCtx.Request == nil {
+			return nil, false
+		}
+		ctx = ginCtx.Request.Context()
+	}
+	tokenContainer, ok := ctx.Value(tokenContainerKey{}).(*TokenContainer)
+	return tokenContainer, ok
+}
+
+// tokenRelayTransport adds the token of the incoming request to outgoing requests to allowed hosts
+type tokenRelayTransport struct {
+	allowedHosts []string
+	base         http.RoundTripper
+}
+
+// NewTokenRelayTransport relays the access token found in the context of outgoing requests as
+// bearer token, but only to allowedHosts. Hosts are matched exactly, including the port if one
+// is given, or by suffix with a leading wildcard like *.example.com. Base defaults to
+// http.DefaultTransport.
+func NewTokenRelayTransport(allowedHosts []string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tokenRelayTransport{allowedHosts: allowedHosts, base: base}
+}
+
+// NewTokenRelayClient returns a client relaying the token of the incoming request, create its
+// requests with http.NewRequestWithContext(ctx.Request.Context(), ...)
+func NewTokenRelayClient(allowedHosts []string) *http.Client {
+	return &http.Client{Transport: NewTokenRelayTransport(allowedHosts, nil)}
+}
+
+func (t *tokenRelayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	tokenContainer, ok := TokenContainerFromContext(req.Context())
+	if !ok || tokenContainer.Token == nil {
+		return t.base.RoundTrip(req)
+	}
+	if !hostAllowed(req.URL.Host, t.allowedHosts) {
+		glog.V(2).Infof("[Gin-OAuth] token not relayed to %s, host not allowed", req.URL.Host)
+		return t.base.RoundTrip(req)
+	}
+	if tokenContainer.KeyCloakToken != nil && tokenContainer.KeyCloakToken.Cnf != nil {
+		glog.V(2).Infof("[Gin-OAuth] token not relayed to %s, token is sender-constrained", req.URL.Host)
+		return t.base.RoundTrip(req)
+	}
+
+	relayed := req.Clone(req.Context())
+	relayed.Header.Set("Authorization", "Bearer "+tokenContainer.Token.AccessToken)
+	return t.base.RoundTrip(relayed)
+}
+
+func hostAllowed(host string, allowedHosts []string) bool {
+	host = strings.ToLower(host)
+	hostname := host
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		hostname = host[:i]
+	}
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		candidate := hostname
+		if strings.Contains(strings.TrimPrefix(allowed, "*."), ":") {
+			candidate = host
+		}
+		if strings.HasPrefix(allowed, "*.") {
+			if strings.HasSuffix(candidate, allowed[1:]) {
+				return true
+			}
+		} else if candidate == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package ginkeycloak
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TokenContainerFromContext(t *testing.T) {
+	token := signRSAToken(createToken(time.Now().Add(time.Minute)))
+	ctx := buildContext(token)
+	Auth(AuthCheck(), KeycloakConfig{})(ctx)
+
+	tokenContainer, ok := TokenContainerFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, token, tokenContainer.Token.AccessToken)
+	_, ok = TokenContainerFromContext(ctx.Request.Context())
+	assert.True(t, ok)
+
+	_, ok = TokenContainerFromContext(buildContext(token))
+	assert.False(t, ok)
+}
+
+func Test_TokenRelay(t *testing.T) {
+	var authorization string
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer downstream.Close()
+	downstreamURL, _ := url.Parse(downstream.URL)
+
+	token := signRSAToken(createToken(time.Now().Add(time.Minute)))
+	ctx := buildContext(token)
+	Auth(AuthCheck(), KeycloakConfig{})(ctx)
+
+	call := func(ctx *gin.Context, client *http.Client) string {
+		authorization = ""
+		req, _ := http.NewRequestWithContext(ctx.Request.Context(), http.MethodGet, downstream.URL, nil)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return authorization
+	}
+	assert.Equal(t, "Bearer "+token, call(ctx, NewTokenRelayClient([]string{downstreamURL.Host})))
+	assert.Equal(t, "Bearer "+token, call(ctx, NewTokenRelayClient([]string{downstreamURL.Hostname()})))
+	assert.Empty(t, call(ctx, NewTokenRelayClient([]string{"api.example.com"})))
+	assert.Empty(t, call(buildContext(token), NewTokenRelayClient([]string{downstreamURL.Host})))
+}
+
+func Test_hostAllowed(t *testing.T) {
+	allowed := []string{"api.example.com", "*.internal.example.com", "localhost:8080"}
+	assert.True(t, hostAllowed("api.example.com", allowed))
+	assert.True(t, hostAllowed("API.example.com:443", allowed))
+	assert.True(t, hostAllowed("orders.internal.example.com", allowed))
+	assert.True(t, hostAllowed("localhost:8080", allowed))
+	assert.False(t, hostAllowed("localhost:9090", allowed))
+	assert.False(t, hostAllowed("internal.example.com", allowed))
+	assert.False(t, hostAllowed("api.example.com.evil.com", allowed))
+	assert.False(t, hostAllowed("evilinternal.example.com", allowed))
+}
@@ -0,0 +1,164 @@
+package ginkeycloak
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+)
+
+// RealmKey is the gin context key of the realm resolved by AuthResolver
+const RealmKey = "realm"
+
+var ErrUnknownRealm = errors.New("No realm configured for the request")
+
+// KeycloakConfigResolver selects the realm configuration per request, so one service can accept
+// tokens of several realms. Keys are cached per realm.
+type KeycloakConfigResolver interface {
+	Resolve(ctx *gin.Context) (KeycloakConfig, error)
+}
+
+// KeycloakConfigResolverFunc adapts a function to a KeycloakConfigResolver
+type KeycloakConfigResolverFunc func(ctx *gin.Context) (KeycloakConfig, error)
+
+func (f KeycloakConfigResolverFunc) Resolve(ctx *gin.Context) (KeycloakConfig, error) {
+	return f(ctx)
+}
+
+// HostResolver selects the config by the host of the request, with or without port
+func HostResolver(configs map[string]KeycloakConfig) KeycloakConfigResolver {
+	return KeycloakConfigResolverFunc(func(ctx *gin.Context) (KeycloakConfig, error) {
+		host := strings.ToLower(ctx.Request.Host)
+		if config, ok := configs[host]; ok {
+			return config, nil
+		}
+		if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+			if config, ok := configs[host[:i]]; ok {
+				return config, nil
+			}
+		}
+		return KeycloakConfig{}, ErrUnknownRealm
+	})
+}
+
+// PathPrefixResolver selects the config with the longest prefix of the request path, prefixes
+// match whole path segments
+func PathPrefixResolver(configs map[string]KeycloakConfig) KeycloakConfigResolver {
+	return KeycloakConfigResolverFunc(func(ctx *gin.Context) (KeycloakConfig, error) {
+		requestPath := ctx.Request.URL.Path
+		longest := -1
+		var resolved KeycloakConfig
+		for prefix, config := range configs {
+			trimmed := strings.TrimSuffix(prefix, "/")
+			if (requestPath == trimmed || strings.HasPrefix(requestPath, trimmed+"/")) && len(trimmed) > longest {
+				longest = len(trimmed)
+				resolved = config
+			}
+		}
+		if longest < 0 {
+			return KeycloakConfig{}, ErrUnknownRealm
+		}
+		return resolved, nil
+	})
+}
+
+// HeaderResolver selects the config by the value of a request header, e.g. X-Tenant set by a gateway
+func HeaderResolver(header string, configs map[string]KeycloakConfig) KeycloakConfigResolver {
+	return KeycloakConfigResolverFunc(func(ctx *gin.Context) (KeycloakConfig, error) {
+		if config, ok := configs[ctx.Request.Header.Get(header)]; ok {
+			return config, nil
+		}
+		return KeycloakConfig{}, ErrUnknownRealm
+	})
+}
+
+// IssuerResolver selects the config by the iss claim of the token before it is verified. Only
+// the issuers of the map are accepted, the token is then verified with the keys of that realm.
+// Encrypted tokens are decrypted with the DecryptionKeys of the configs to read the claim.
+func IssuerResolver(configs map[string]KeycloakConfig) KeycloakConfigResolver {
+	return KeycloakConfigResolverFunc(func(ctx *gin.Context) (KeycloakConfig, error) {
+		issuer, err := unverifiedIssuer(ctx.Request, configs)
+		if err != nil {
+			return KeycloakConfig{}, err
+		}
+		if config, ok := configs[issuer]; ok {
+			return config, nil
+		}
+		return KeycloakConfig{}, ErrUnknownRealm
+	})
+}
+
+// unverifiedIssuer reads the iss claim of the token of the request without verifying it
+func unverifiedIssuer(r *http.Request, configs map[string]KeycloakConfig) (string, error) {
+	token, err := extractToken(r)
+	if err != nil && r.Header.Get("Authorization") == "" {
+		for _, config := range configs {
+			if config.TokenCookieName == "" {
+				continue
+			}
+			if token, err = extractCookieToken(r, config.TokenCookieName); err == nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return "", ErrNoToken
+	}
+	parsedJWT, err := unverifiedJWT(token.AccessToken, configs)
+	if err != nil {
+		return "", ErrNoToken
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+	}
+	if err = parsedJWT.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", ErrNoToken
+	}
+	return claims.Iss, nil
+}
+
+// unverifiedJWT parses the signed token, encrypted tokens are decrypted with the first config
+// holding a matching key
+func unverifiedJWT(rawToken string, configs map[string]KeycloakConfig) (*jwt.JSONWebToken, error) {
+	if !isEncrypted(rawToken) {
+		return jwt.ParseSigned(rawToken)
+	}
+	for _, config := range configs {
+		if len(config.DecryptionKeys) == 0 {
+			continue
+		}
+		if parsedJWT, err := parseSignedToken(rawToken, config); err == nil {
+			return parsedJWT, nil
+		}
+	}
+	return nil, ErrTokenEncrypted
+}
+
+// AuthResolver is Auth with the config resolved per request. The realm of the config is set in
+// the gin context under RealmKey, use PerRealm for access rules that differ between realms.
+func AuthResolver(resolver KeycloakConfigResolver, accessCheckFunctions ...AccessCheckFunction) gin.HandlerFunc {
+	tokenCacheScope := newTokenCacheScope()
+	return func(ctx *gin.Context) {
+		config, err := resolver.Resolve(ctx)
+		if err != nil {
+			glog.Errorf("[Gin-OAuth] Can not resolve realm, caused by: %s", err)
+			_ = ctx.AbortWithError(http.StatusUnauthorized, err)
+			return
+		}
+		config.tokenCacheScope = tokenCacheScope
+		ctx.Set(RealmKey, config.Realm)
+		authenticate(ctx, config, accessCheckFunctions)
+	}
+}
+
+// PerRealm applies the access check function of the realm resolved by AuthResolver, requests of
+// other realms are denied
+func PerRealm(checks map[string]AccessCheckFunction) AccessCheckFunction {
+	return func(tc *TokenContainer, ctx *gin.Context) bool {
+		check, ok := checks[ctx.GetString(RealmKey)]
+		return ok && check(tc, ctx)
+	}
+}
@@ -0,0 +1,130 @@
+package ginkeycloak
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/go-jose/go-jose.v2"
+)
+
+func realmToken(iss string) string {
+	token := createToken(time.Now().Add(time.Minute))
+	token.Iss = iss
+	return signRSAToken(token)
+}
+
+func Test_HostResolver(t *testing.T) {
+	configA, configB := KeycloakConfig{Realm: "a"}, KeycloakConfig{Realm: "b"}
+	cacheTestKeys(configA)
+	authFunc := AuthResolver(HostResolver(map[string]KeycloakConfig{"a.example.com": configA, "b.example.com": configB}), AuthCheck())
+
+	ctx := buildContext(realmToken(""))
+	ctx.Request.Host = "a.example.com:8080"
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+	assert.Equal(t, "a", ctx.GetString(RealmKey))
+
+	ctx = buildContext(realmToken(""))
+	ctx.Request.Host = "b.example.com"
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 1, "keys of realm a are not used for realm b")
+
+	ctx = buildContext(realmToken(""))
+	ctx.Request.Host = "c.example.com"
+	authFunc(ctx)
+	assert.Equal(t, ErrUnknownRealm, ctx.Errors[0].Err)
+	assert.Equal(t, http.StatusUnauthorized, ctx.Writer.Status())
+}
+
+func Test_PathPrefixResolver(t *testing.T) {
+	resolver := PathPrefixResolver(map[string]KeycloakConfig{
+		"/tenants/a":         {Realm: "a"},
+		"/tenants/a/special": {Realm: "special"},
+	})
+	for requestPath, realm := range map[string]string{
+		"/tenants/a":           "a",
+		"/tenants/a/orders":    "a",
+		"/tenants/a/special/1": "special",
+		"/tenants/ab":          "",
+	} {
+		ctx := buildContext("")
+		ctx.Request.URL.Path = requestPath
+		config, err := resolver.Resolve(ctx)
+		assert.Equal(t, realm, config.Realm, requestPath)
+		assert.Equal(t, realm == "", err == ErrUnknownRealm, requestPath)
+	}
+}
+
+func Test_HeaderResolver(t *testing.T) {
+	resolver := HeaderResolver("X-Tenant", map[string]KeycloakConfig{"a": {Realm: "a"}})
+	ctx := buildContext("")
+	ctx.Request.Header.Set("X-Tenant", "a")
+	config, err := resolver.Resolve(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", config.Realm)
+
+	ctx.Request.Header.Set("X-Tenant", "b")
+	_, err = resolver.Resolve(ctx)
+	assert.Equal(t, ErrUnknownRealm, err)
+}
+
+func Test_IssuerResolver(t *testing.T) {
+	configA := KeycloakConfig{Realm: "a"}
+	cacheTestKeys(configA)
+	authFunc := AuthResolver(IssuerResolver(map[string]KeycloakConfig{"https://keycloak.example.com/realms/a": configA}), AuthCheck())
+
+	ctx := buildContext(realmToken("https://keycloak.example.com/realms/a"))
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+
+	ctx = buildContext(realmToken("https://evil.example.com/realms/a"))
+	authFunc(ctx)
+	assert.Equal(t, ErrUnknownRealm, ctx.Errors[0].Err)
+
+	ctx = buildContext("not a token")
+	authFunc(ctx)
+	assert.Equal(t, ErrNoToken, ctx.Errors[0].Err)
+}
+
+func Test_IssuerResolver_encrypted_token(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	configA := KeycloakConfig{Realm: "a", DecryptionKeys: []jose.JSONWebKey{{Key: rsaKey, KeyID: "enc"}}}
+	configB := KeycloakConfig{Realm: "b"}
+	cacheTestKeys(configA)
+	authFunc := AuthResolver(IssuerResolver(map[string]KeycloakConfig{
+		"https://keycloak.example.com/realms/a": configA,
+		"https://keycloak.example.com/realms/b": configB,
+	}), AuthCheck())
+
+	ctx := buildContext(encryptToken(realmToken("https://keycloak.example.com/realms/a"), jose.RSA_OAEP, &rsaKey.PublicKey))
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+	assert.Equal(t, "a", ctx.GetString(RealmKey))
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ctx = buildContext(encryptToken(realmToken("https://keycloak.example.com/realms/a"), jose.RSA_OAEP, &otherKey.PublicKey))
+	authFunc(ctx)
+	assert.Equal(t, ErrNoToken, ctx.Errors[0].Err)
+}
+
+func Test_PerRealm(t *testing.T) {
+	configA, configB := KeycloakConfig{Realm: "a"}, KeycloakConfig{Realm: "b"}
+	cacheTestKeys(configA)
+	cacheTestKeys(configB)
+	resolver := HeaderResolver("X-Tenant", map[string]KeycloakConfig{"a": configA, "b": configB})
+	authFunc := AuthResolver(resolver, PerRealm(map[string]AccessCheckFunction{
+		"a": GroupCheck([]AccessTuple{{Service: serviceName, Role: validRole}}),
+		"b": GroupCheck([]AccessTuple{{Service: serviceName, Role: invalidRole}}),
+	}))
+
+	for tenant, allowed := range map[string]bool{"a": true, "b": false} {
+		ctx := buildContext(realmToken(""))
+		ctx.Request.Header.Set("X-Tenant", tenant)
+		authFunc(ctx)
+		assert.Equal(t, allowed, len(ctx.Errors) == 0, tenant)
+	}
+}
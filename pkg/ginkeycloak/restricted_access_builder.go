@@ -1,6 +1,8 @@
 package ginkeycloak
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
 )
@@ -11,12 +13,20 @@ type BuilderConfig struct {
 	Realm                string
 	FullCertsPath        *string
 	DisableSecurityCheck bool
+	RoleHierarchy        *RoleHierarchy
+	AcrValues            []string
+	AuthorizedParties    []string
+	EnableCORS           bool
+	TokenCookieName      string
+	CSRF                 *CSRFProtection
 }
 
 type RestrictedAccessBuilder interface {
 	RestrictButForRole(role string) RestrictedAccessBuilder
 	RestrictButForUid(uid string) RestrictedAccessBuilder
 	RestrictButForRealm(realmName string) RestrictedAccessBuilder
+	RequireAcr(acr string) RestrictedAccessBuilder
+	RequireMaxAuthAge(maxAge time.Duration) RestrictedAccessBuilder
 	Build() gin.HandlerFunc
 }
 
@@ -24,11 +34,12 @@ type restrictedAccessBuilderImpl struct {
 	allowedRoles  []AccessTuple
 	allowedUids   []AccessTuple
 	allowedRealms []string
+	stepUp        StepUpConfig
 	config        BuilderConfig
 }
 
 func NewAccessBuilder(config BuilderConfig) RestrictedAccessBuilder {
-	builder := restrictedAccessBuilderImpl{config: config, allowedRoles: []AccessTuple{}}
+	builder := restrictedAccessBuilderImpl{config: config, allowedRoles: []AccessTuple{}, stepUp: StepUpConfig{AcrValues: config.AcrValues}}
 	return builder
 }
 
@@ -47,6 +58,16 @@ func (builder restrictedAccessBuilderImpl) RestrictButForRealm(realmName string)
 	return builder
 }
 
+func (builder restrictedAccessBuilderImpl) RequireAcr(acr string) RestrictedAccessBuilder {
+	builder.stepUp.MinAcr = acr
+	return builder
+}
+
+func (builder restrictedAccessBuilderImpl) RequireMaxAuthAge(maxAge time.Duration) RestrictedAccessBuilder {
+	builder.stepUp.MaxAge = maxAge
+	return builder
+}
+
 func (builder restrictedAccessBuilderImpl) Build() gin.HandlerFunc {
 	if builder.config.DisableSecurityCheck {
 		glog.Warningf("[ginkeycloak] access check is disabled")
@@ -57,18 +78,27 @@ func (builder restrictedAccessBuilderImpl) Build() gin.HandlerFunc {
 
 func (builder restrictedAccessBuilderImpl) keycloakConfig() KeycloakConfig {
 	return KeycloakConfig{
-		Url:           builder.config.Url,
-		Realm:         builder.config.Realm,
-		FullCertsPath: builder.config.FullCertsPath,
+		Url:               builder.config.Url,
+		Realm:             builder.config.Realm,
+		FullCertsPath:     builder.config.FullCertsPath,
+		RoleHierarchy:     builder.config.RoleHierarchy,
+		AuthorizedParties: builder.config.AuthorizedParties,
+		EnableCORS:        builder.config.EnableCORS,
+		TokenCookieName:   builder.config.TokenCookieName,
+		CSRF:              builder.config.CSRF,
 	}
 }
 
 func (builder restrictedAccessBuilderImpl) checkIfOneConditionMatches() AccessCheckFunction {
-	return func(tc *TokenContainer, ctx *gin.Context) bool {
+	check := func(tc *TokenContainer, ctx *gin.Context) bool {
 		checkRoles := GroupCheck(builder.allowedRoles)(tc, ctx)
 		checkUids := UidCheck(builder.allowedUids)(tc, ctx)
 		checkRealm := RealmCheck(builder.allowedRealms)(tc, ctx)
 
 		return checkRoles || checkUids || checkRealm
 	}
+	if builder.stepUp.MinAcr == "" && builder.stepUp.MaxAge <= 0 {
+		return check
+	}
+	return StepUpCheck(builder.stepUp, check)
 }
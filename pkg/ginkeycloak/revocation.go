@@ -0,0 +1,224 @@
+package ginkeycloak
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/patrickmn/go-cache"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+)
+
+// RevokedSessionTTL is how long revoked sessions and subjects are remembered, it should
+// exceed the SSO session max lifespan of the realm
+var RevokedSessionTTL = 24 * time.Hour
+
+// MaxAdminRequestSize limits the body of admin and logout requests sent by Keycloak
+var MaxAdminRequestSize int64 = 64 * 1024
+
+const backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+var ErrTokenRevoked = errors.New("Token has been revoked")
+
+// RevocationStore keeps the not-before policy per realm and revoked sessions pushed by Keycloak
+type RevocationStore struct {
+	mu        sync.RWMutex
+	notBefore map[string]int64
+	sessions  *cache.Cache
+	subjects  *cache.Cache
+}
+
+func NewRevocationStore() *RevocationStore {
+	return &RevocationStore{
+		notBefore: map[string]int64{},
+		sessions:  cache.New(RevokedSessionTTL, time.Hour),
+		subjects:  cache.New(RevokedSessionTTL, time.Hour),
+	}
+}
+
+// SetNotBefore rejects all tokens of the realm issued before notBefore, it never moves backwards
+func (s *RevocationStore) SetNotBefore(realm string, notBefore int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if notBefore > s.notBefore[realm] {
+		s.notBefore[realm] = notBefore
+	}
+}
+
+func (s *RevocationStore) NotBefore(realm string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.notBefore[realm]
+}
+
+// RevokeSession rejects all tokens with the given sid or session_state
+func (s *RevocationStore) RevokeSession(sid string) {
+	s.sessions.Set(sid, true, cache.DefaultExpiration)
+}
+
+func (s *RevocationStore) IsSessionRevoked(sid string) bool {
+	_, revoked := s.sessions.Get(sid)
+	return revoked
+}
+
+// RevokeSubject rejects all tokens of the subject issued up to before
+func (s *RevocationStore) RevokeSubject(sub string, before int64) {
+	if entry, exists := s.subjects.Get(sub); exists && entry.(int64) >= before {
+		return
+	}
+	s.subjects.Set(sub, before, cache.DefaultExpiration)
+}
+
+func (s *RevocationStore) check(token *KeyCloakToken, realm string) error {
+	if token.Iat < s.NotBefore(realm) {
+		return fmt.Errorf("%w: issued before not-before policy", ErrTokenRevoked)
+	}
+	for _, sid := range []string{token.Sid, token.SessionState} {
+		if sid != "" && s.IsSessionRevoked(sid) {
+			return fmt.Errorf("%w: session %s logged out", ErrTokenRevoked, sid)
+		}
+	}
+	if entry, exists := s.subjects.Get(token.Sub); exists && token.Iat <= entry.(int64) {
+		return fmt.Errorf("%w: subject logged out", ErrTokenRevoked)
+	}
+	return nil
+}
+
+type adminAction struct {
+	Id         string `json:"id"`
+	Expiration int64  `json:"expiration"`
+	Resource   string `json:"resource"`
+	Action     string `json:"action"`
+	NotBefore  int64  `json:"notBefore"`
+}
+
+type logoutToken struct {
+	jwt.Claims
+	Sid    string                 `json:"sid"`
+	Nonce  string                 `json:"nonce"`
+	Events map[string]interface{} `json:"events"`
+}
+
+// verifyRealmSigned checks a JWS sent by Keycloak against the realm keys
+func verifyRealmSigned(raw string, config KeycloakConfig, claims interface{}) error {
+	parsedJWT, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return err
+	}
+	key, err := getPublicKey(parsedJWT.Headers[0].KeyID, config)
+	if err != nil {
+		return err
+	}
+	return parsedJWT.Claims(key, claims)
+}
+
+// PushNotBeforeHandler handles the k_push_not_before admin request Keycloak sends when an admin
+// pushes a not-before revocation policy, mount it at <admin url>/k_push_not_before.
+// It panics if config.Revocations is not set.
+func PushNotBeforeHandler(config KeycloakConfig) gin.HandlerFunc {
+	mustHaveRevocations(config)
+	return func(ctx *gin.Context) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, MaxAdminRequestSize))
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		var action adminAction
+		if err = verifyRealmSigned(string(body), config, &action); err != nil {
+			glog.Errorf("[Gin-OAuth] Invalid push not-before request: %s", err)
+			_ = ctx.AbortWithError(http.StatusUnauthorized, err)
+			return
+		}
+		if action.Action != "PUSH_NOT_BEFORE" || time.Now().Unix() > action.Expiration {
+			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("Invalid or expired admin action"))
+			return
+		}
+		config.Revocations.SetNotBefore(config.Realm, action.NotBefore)
+		glog.Infof("[Gin-OAuth] not-before of realm %s set to %d", config.Realm, action.NotBefore)
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// BackchannelLogoutHandler handles OpenID Connect back-channel logout requests and revokes the
+// session (sid) or all sessions of the subject (sub) of the logout token. The token must be issued
+// by the realm of config to clientID. It panics if config.Revocations is not set.
+func BackchannelLogoutHandler(config KeycloakConfig, clientID string) gin.HandlerFunc {
+	mustHaveRevocations(config)
+	return func(ctx *gin.Context) {
+		claims, ok := parseLogoutToken(ctx, config, clientID)
+		if !ok {
+			return
+		}
+		revoke(claims, config.Revocations)
+		ctx.Status(http.StatusOK)
+	}
+}
+
+func mustHaveRevocations(config KeycloakConfig) {
+	if config.Revocations == nil {
+		panic("ginkeycloak: KeycloakConfig.Revocations must be set to handle revocation requests")
+	}
+}
+
+// parseLogoutToken verifies the logout_token of a back-channel logout request and aborts the request if it is invalid
+func parseLogoutToken(ctx *gin.Context, config KeycloakConfig, clientID string) (*logoutToken, bool) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, MaxAdminRequestSize)
+	raw := ctx.PostForm("logout_token")
+	if raw == "" {
+		_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("No logout_token"))
+		return nil, false
+	}
+	var claims logoutToken
+	if err := verifyRealmSigned(raw, config, &claims); err != nil {
+		glog.Errorf("[Gin-OAuth] Invalid logout token: %s", err)
+		_ = ctx.AbortWithError(http.StatusBadRequest, err)
+		return nil, false
+	}
+	if err := validateLogoutToken(claims, config, clientID); err != nil {
+		glog.Errorf("[Gin-OAuth] Invalid logout token: %s", err)
+		_ = ctx.AbortWithError(http.StatusBadRequest, err)
+		return nil, false
+	}
+	glog.Infof("[Gin-OAuth] back-channel logout of session %q subject %q", claims.Sid, claims.Subject)
+	return &claims, true
+}
+
+func revoke(claims *logoutToken, revocations *RevocationStore) {
+	if claims.Sid != "" {
+		revocations.RevokeSession(claims.Sid)
+	} else {
+		revocations.RevokeSubject(claims.Subject, time.Now().Unix())
+	}
+}
+
+func validateLogoutToken(claims logoutToken, config KeycloakConfig, clientID string) error {
+	if config.Url == "" || clientID == "" {
+		return errors.New("issuer and client id are required to validate logout tokens")
+	}
+	issuer, err := realmURL(config)
+	if err != nil {
+		return err
+	}
+	if claims.Issuer != issuer {
+		return errors.New("logout token issuer " + claims.Issuer + " does not match " + issuer)
+	}
+	if !claims.Audience.Contains(clientID) {
+		return errors.New("logout token is not issued to " + clientID)
+	}
+	if claims.Sid == "" && claims.Subject == "" {
+		return errors.New("logout token has neither sid nor sub")
+	}
+	if claims.Nonce != "" {
+		return errors.New("logout token must not contain a nonce")
+	}
+	if _, ok := claims.Events[backchannelLogoutEvent]; !ok {
+		return errors.New("logout token has no back-channel logout event")
+	}
+	return claims.ValidateWithLeeway(jwt.Expected{Time: time.Now()}, time.Minute)
+}
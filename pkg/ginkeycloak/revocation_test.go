@@ -0,0 +1,155 @@
+package ginkeycloak
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func sessionToken(sid string, iat time.Time) string {
+	token := createToken(time.Now().Add(time.Minute))
+	token.Sid = sid
+	token.Sub = "subject-" + sid
+	token.Iat = iat.Unix()
+	return signRSAToken(token)
+}
+
+func Test_PushNotBefore(t *testing.T) {
+	config := KeycloakConfig{Realm: "test", Revocations: NewRevocationStore()}
+	cacheTestKeys(config)
+	router := gin.New()
+	router.POST("/k_push_not_before", PushNotBeforeHandler(config))
+
+	oldToken := sessionToken("old", time.Now().Add(-time.Minute))
+	action := signRSAToken(adminAction{
+		Id:         "1",
+		Action:     "PUSH_NOT_BEFORE",
+		Expiration: time.Now().Add(time.Minute).Unix(),
+		NotBefore:  time.Now().Unix(),
+	})
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/k_push_not_before", strings.NewReader(action)))
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+
+	authFunc := Auth(AuthCheck(), config)
+	ctx := buildContext(oldToken)
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrTokenRevoked))
+
+	ctx = buildContext(sessionToken("new", time.Now().Add(time.Second)))
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+}
+
+func Test_PushNotBefore_rejects_unsigned_request(t *testing.T) {
+	config := KeycloakConfig{Realm: "test", Revocations: NewRevocationStore()}
+	cacheTestKeys(config)
+	router := gin.New()
+	router.POST("/k_push_not_before", PushNotBeforeHandler(config))
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/k_push_not_before", strings.NewReader("not a jws")))
+
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, int64(0), config.Revocations.NotBefore("test"))
+}
+
+func postLogoutToken(router *gin.Engine, logout string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/backchannel-logout", strings.NewReader(url.Values{"logout_token": {logout}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func Test_BackchannelLogout(t *testing.T) {
+	config := KeycloakConfig{Url: "http://keycloak", Realm: "test", Revocations: NewRevocationStore()}
+	cacheTestKeys(config)
+	router := gin.New()
+	router.POST("/backchannel-logout", BackchannelLogoutHandler(config, "my-client"))
+
+	logout := signRSAToken(map[string]interface{}{
+		"iss":    "http://keycloak/realms/test",
+		"aud":    "my-client",
+		"iat":    time.Now().Unix(),
+		"jti":    "logout-1",
+		"sid":    "revoked",
+		"events": map[string]interface{}{backchannelLogoutEvent: map[string]interface{}{}},
+	})
+	assert.Equal(t, http.StatusOK, postLogoutToken(router, logout).Code)
+
+	authFunc := Auth(AuthCheck(), config)
+	ctx := buildContext(sessionToken("revoked", time.Now()))
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrTokenRevoked))
+
+	ctx = buildContext(sessionToken("active", time.Now()))
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+}
+
+func Test_BackchannelLogout_requires_event(t *testing.T) {
+	config := KeycloakConfig{Url: "http://keycloak", Realm: "test", Revocations: NewRevocationStore()}
+	cacheTestKeys(config)
+	router := gin.New()
+	router.POST("/backchannel-logout", BackchannelLogoutHandler(config, "my-client"))
+
+	logout := signRSAToken(map[string]interface{}{
+		"iss": "http://keycloak/realms/test",
+		"aud": "my-client",
+		"iat": time.Now().Unix(),
+		"sid": "revoked",
+	})
+
+	assert.Equal(t, http.StatusBadRequest, postLogoutToken(router, logout).Code)
+	assert.False(t, config.Revocations.IsSessionRevoked("revoked"))
+}
+
+func Test_BackchannelLogout_checks_issuer_and_audience(t *testing.T) {
+	config := KeycloakConfig{Url: "http://keycloak", Realm: "test", Revocations: NewRevocationStore()}
+	cacheTestKeys(config)
+	router := gin.New()
+	router.POST("/backchannel-logout", BackchannelLogoutHandler(config, "my-client"))
+
+	for _, claims := range []map[string]interface{}{
+		{"iss": "http://keycloak/realms/test", "aud": "other-client"},
+		{"iss": "http://keycloak/realms/other", "aud": "my-client"},
+		{"aud": "my-client"},
+	} {
+		claims["iat"] = time.Now().Unix()
+		claims["sid"] = "revoked"
+		claims["events"] = map[string]interface{}{backchannelLogoutEvent: map[string]interface{}{}}
+		assert.Equal(t, http.StatusBadRequest, postLogoutToken(router, signRSAToken(claims)).Code)
+	}
+	assert.False(t, config.Revocations.IsSessionRevoked("revoked"))
+
+	noIssuer := KeycloakConfig{Realm: "test", Revocations: NewRevocationStore()}
+	cacheTestKeys(noIssuer)
+	router = gin.New()
+	router.POST("/backchannel-logout", BackchannelLogoutHandler(noIssuer, "my-client"))
+	logout := signRSAToken(map[string]interface{}{
+		"iss":    "/realms/test",
+		"aud":    "my-client",
+		"iat":    time.Now().Unix(),
+		"sid":    "revoked",
+		"events": map[string]interface{}{backchannelLogoutEvent: map[string]interface{}{}},
+	})
+	assert.Equal(t, http.StatusBadRequest, postLogoutToken(router, logout).Code)
+	assert.False(t, noIssuer.Revocations.IsSessionRevoked("revoked"))
+}
+
+func Test_Revocation_handlers_require_store(t *testing.T) {
+	config := KeycloakConfig{Url: "http://keycloak", Realm: "test"}
+
+	assert.Panics(t, func() { PushNotBeforeHandler(config) })
+	assert.Panics(t, func() { BackchannelLogoutHandler(config, "app") })
+}
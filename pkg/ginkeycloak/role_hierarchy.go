@@ -0,0 +1,166 @@
+package ginkeycloak
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// RoleRef identifies a realm role (empty Client) or a client role
+type RoleRef struct {
+	Client string
+	Role   string
+}
+
+// RoleHierarchy maps composite roles to the roles they imply, e.g. admin -> editor -> viewer
+type RoleHierarchy struct {
+	composites map[RoleRef][]RoleRef
+}
+
+func NewRoleHierarchy() *RoleHierarchy {
+	return &RoleHierarchy{composites: map[RoleRef][]RoleRef{}}
+}
+
+func (h *RoleHierarchy) AddComposite(role RoleRef, implied ...RoleRef) *RoleHierarchy {
+	h.composites[role] = append(h.composites[role], implied...)
+	return h
+}
+
+func (h *RoleHierarchy) AddRealmComposite(role string, implied ...string) *RoleHierarchy {
+	for _, impliedRole := range implied {
+		h.AddComposite(RoleRef{Role: role}, RoleRef{Role: impliedRole})
+	}
+	return h
+}
+
+func (h *RoleHierarchy) AddClientComposite(client string, role string, implied ...string) *RoleHierarchy {
+	for _, impliedRole := range implied {
+		h.AddComposite(RoleRef{Client: client, Role: role}, RoleRef{Client: client, Role: impliedRole})
+	}
+	return h
+}
+
+// Implies reports whether holding role grants target, either directly or through composites
+func (h *RoleHierarchy) Implies(role RoleRef, target RoleRef) bool {
+	if role == target {
+		return true
+	}
+	if h == nil {
+		return false
+	}
+	visited := map[RoleRef]bool{role: true}
+	queue := []RoleRef{role}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, implied := range h.composites[current] {
+			if implied == target {
+				return true
+			}
+			if !visited[implied] {
+				visited[implied] = true
+				queue = append(queue, implied)
+			}
+		}
+	}
+	return false
+}
+
+type roleHierarchyJSON struct {
+	Realm   map[string][]string            `json:"realm"`
+	Clients map[string]map[string][]string `json:"clients"`
+}
+
+// LoadRoleHierarchyJSON reads a hierarchy of the form
+// {"realm": {"admin": ["editor"]}, "clients": {"myService": {"admin": ["editor"]}}}
+func LoadRoleHierarchyJSON(r io.Reader) (*RoleHierarchy, error) {
+	var doc roleHierarchyJSON
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	h := NewRoleHierarchy()
+	for role, implied := range doc.Realm {
+		h.AddRealmComposite(role, implied...)
+	}
+	for client, roles := range doc.Clients {
+		for role, implied := range roles {
+			h.AddClientComposite(client, role, implied...)
+		}
+	}
+	return h, nil
+}
+
+type realmExportRole struct {
+	Name       string `json:"name"`
+	Composite  bool   `json:"composite"`
+	Composites *struct {
+		Realm  []string            `json:"realm"`
+		Client map[string][]string `json:"client"`
+	} `json:"composites"`
+}
+
+type realmExport struct {
+	Roles struct {
+		Realm  []realmExportRole            `json:"realm"`
+		Client map[string][]realmExportRole `json:"client"`
+	} `json:"roles"`
+}
+
+// LoadRoleHierarchyFromRealmExport reads the composite role definitions of a Keycloak realm export
+func LoadRoleHierarchyFromRealmExport(r io.Reader) (*RoleHierarchy, error) {
+	var export realmExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, err
+	}
+	h := NewRoleHierarchy()
+	addExportRoles := func(client string, roles []realmExportRole) {
+		for _, role := range roles {
+			if !role.Composite || role.Composites == nil {
+				continue
+			}
+			ref := RoleRef{Client: client, Role: role.Name}
+			for _, implied := range role.Composites.Realm {
+				h.AddComposite(ref, RoleRef{Role: implied})
+			}
+			for impliedClient, impliedRoles := range role.Composites.Client {
+				for _, implied := range impliedRoles {
+					h.AddComposite(ref, RoleRef{Client: impliedClient, Role: implied})
+				}
+			}
+		}
+	}
+	addExportRoles("", export.Roles.Realm)
+	for client, roles := range export.Roles.Client {
+		addExportRoles(client, roles)
+	}
+	return h, nil
+}
+
+func (t *TokenContainer) hasRole(target RoleRef) bool {
+	for _, role := range t.KeyCloakToken.RealmAccess.Roles {
+		if t.RoleHierarchy.Implies(RoleRef{Role: role}, target) {
+			return true
+		}
+	}
+	for client, serviceRoles := range t.KeyCloakToken.ResourceAccess {
+		for _, role := range serviceRoles.Roles {
+			if t.RoleHierarchy.Implies(RoleRef{Client: client, Role: role}, target) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasClientRole reports whether the token grants a role of the client. Roles of an empty client
+// are only looked up in resource_access, they never match realm roles of the same name.
+func (t *TokenContainer) hasClientRole(client string, role string) bool {
+	if client == "" {
+		for _, serviceRole := range t.KeyCloakToken.ResourceAccess[client].Roles {
+			if serviceRole == role {
+				return true
+			}
+		}
+		return false
+	}
+	return t.hasRole(RoleRef{Client: client, Role: role})
+}
@@ -0,0 +1,106 @@
+package ginkeycloak
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const realmExportWithComposites = `{
+  "roles": {
+    "realm": [
+      {"name": "admin", "composite": true, "composites": {"realm": ["editor"], "client": {"myService": ["manage"]}}},
+      {"name": "editor", "composite": true, "composites": {"realm": ["viewer"]}},
+      {"name": "viewer", "composite": false}
+    ],
+    "client": {
+      "myService": [
+        {"name": "manage", "composite": true, "composites": {"client": {"myService": ["read"]}}}
+      ]
+    }
+  }
+}`
+
+func Test_RoleHierarchy_transitive_realm_role(t *testing.T) {
+	hierarchy := NewRoleHierarchy().
+		AddRealmComposite(validRealmRole, "editor").
+		AddRealmComposite("editor", "viewer")
+	config := builderConfiig
+	config.RoleHierarchy = hierarchy
+
+	authFunc := NewAccessBuilder(config).
+		RestrictButForRealm("viewer").
+		Build()
+
+	for _, token := range tokens {
+		ctx := buildContext(token)
+		authFunc(ctx)
+		assert.True(t, len(ctx.Errors) == 0)
+	}
+}
+
+func Test_RoleHierarchy_client_role(t *testing.T) {
+	config := builderConfiig
+	config.RoleHierarchy = NewRoleHierarchy().AddClientComposite(serviceName, validRole, "read")
+
+	authFunc := NewAccessBuilder(config).
+		RestrictButForRole("read").
+		Build()
+
+	for _, token := range tokens {
+		ctx := buildContext(token)
+		authFunc(ctx)
+		assert.True(t, len(ctx.Errors) == 0)
+	}
+}
+
+func Test_RoleHierarchy_does_not_imply_upwards(t *testing.T) {
+	config := builderConfiig
+	config.RoleHierarchy = NewRoleHierarchy().AddRealmComposite("admin", validRealmRole)
+
+	authFunc := NewAccessBuilder(config).
+		RestrictButForRealm("admin").
+		Build()
+
+	for _, token := range tokens {
+		ctx := buildContext(token)
+		authFunc(ctx)
+		assert.True(t, len(ctx.Errors) == 1)
+		assert.Equal(t, "Access to the Resource is forbidden", ctx.Errors[0].Err.Error())
+	}
+}
+
+func Test_RoleHierarchy_from_realm_export(t *testing.T) {
+	hierarchy, err := LoadRoleHierarchyFromRealmExport(strings.NewReader(realmExportWithComposites))
+	assert.NoError(t, err)
+
+	assert.True(t, hierarchy.Implies(RoleRef{Role: "admin"}, RoleRef{Role: "viewer"}))
+	assert.True(t, hierarchy.Implies(RoleRef{Role: "admin"}, RoleRef{Client: serviceName, Role: "read"}))
+	assert.False(t, hierarchy.Implies(RoleRef{Role: "viewer"}, RoleRef{Role: "editor"}))
+}
+
+func Test_RoleHierarchy_from_json_with_cycle(t *testing.T) {
+	hierarchy, err := LoadRoleHierarchyJSON(strings.NewReader(`{"realm": {"a": ["b"], "b": ["a", "c"]}}`))
+	assert.NoError(t, err)
+
+	assert.True(t, hierarchy.Implies(RoleRef{Role: "a"}, RoleRef{Role: "c"}))
+	assert.False(t, hierarchy.Implies(RoleRef{Role: "c"}, RoleRef{Role: "a"}))
+}
+
+func Test_GroupCheck_empty_service_does_not_match_realm_role(t *testing.T) {
+	token := createToken(time.Now().Add(time.Hour))
+	tc := &TokenContainer{KeyCloakToken: &token}
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	assert.False(t, GroupCheck([]AccessTuple{{Service: "", Role: validRealmRole}})(tc, ctx))
+	assert.True(t, GroupCheck([]AccessTuple{{Service: serviceName, Role: validRole}})(tc, ctx))
+
+	tc.RoleHierarchy = NewRoleHierarchy().AddRealmComposite(validRealmRole, "admin")
+	assert.False(t, GroupCheck([]AccessTuple{{Service: "", Role: "admin"}})(tc, ctx))
+	assert.True(t, RealmCheck([]string{"admin"})(tc, ctx))
+}
@@ -0,0 +1,330 @@
+package ginkeycloak
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/patrickmn/go-cache"
+)
+
+// DefaultSessionTTL is used if LoginConfig.SessionTTL is not set
+var DefaultSessionTTL = 10 * time.Hour
+
+// SessionStore keeps sessions server side, the browser only holds the opaque session id
+type SessionStore interface {
+	// Get returns ErrNoSession if the session does not exist or has expired
+	Get(id string) (*Session, error)
+	Save(session *Session, ttl time.Duration) error
+	Delete(id string) error
+	// DeleteBySid removes all sessions belonging to the Keycloak session sid
+	DeleteBySid(sid string) error
+}
+
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions *cache.Cache
+	sids     map[string]map[string]bool
+}
+
+// NewMemorySessionStore keeps sessions in memory, expired sessions are evicted every cleanupInterval
+func NewMemorySessionStore(cleanupInterval time.Duration) SessionStore {
+	s := &memorySessionStore{
+		sessions: cache.New(DefaultSessionTTL, cleanupInterval),
+		sids:     map[string]map[string]bool{},
+	}
+	s.sessions.OnEvicted(func(id string, value interface{}) {
+		s.unindex(id, value.(*Session).Sid)
+	})
+	return s
+}
+
+func (s *memorySessionStore) Get(id string) (*Session, error) {
+	value, ok := s.sessions.Get(id)
+	if !ok {
+		return nil, ErrNoSession
+	}
+	session := *value.(*Session)
+	return &session, nil
+}
+
+func (s *memorySessionStore) Save(session *Session, ttl time.Duration) error {
+	stored := *session
+	s.sessions.Set(session.ID, &stored, ttl)
+	if session.Sid != "" {
+		s.mu.Lock()
+		if s.sids[session.Sid] == nil {
+			s.sids[session.Sid] = map[string]bool{}
+		}
+		s.sids[session.Sid][session.ID] = true
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *memorySessionStore) Delete(id string) error {
+	s.sessions.Delete(id)
+	return nil
+}
+
+func (s *memorySessionStore) DeleteBySid(sid string) error {
+	s.mu.Lock()
+	ids := s.sids[sid]
+	delete(s.sids, sid)
+	s.mu.Unlock()
+	for id := range ids {
+		s.sessions.Delete(id)
+	}
+	return nil
+}
+
+func (s *memorySessionStore) unindex(id string, sid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ids, ok := s.sids[sid]; ok {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(s.sids, sid)
+		}
+	}
+}
+
+type fileSession struct {
+	Session   *Session  `json:"session"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type fileSessionStore struct {
+	dir             string
+	cleanupInterval time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewFileSessionStore keeps every session in a file below dir, sessions survive restarts and can
+// be shared by processes on the same host. Expired sessions are removed when they are read and
+// by a sweep at most every cleanupInterval when sessions are saved.
+func NewFileSessionStore(dir string, cleanupInterval time.Duration) (SessionStore, error) {
+	for _, sub := range []string{"sessions", "sids"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &fileSessionStore{dir: dir, cleanupInterval: cleanupInterval, lastSweep: time.Now()}, nil
+}
+
+// hashName keeps ids out of file names and makes them safe to use as such
+func hashName(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *fileSessionStore) sessionFile(id string) string {
+	return filepath.Join(s.dir, "sessions", hashName(id))
+}
+
+func (s *fileSessionStore) sidDir(sid string) string {
+	return filepath.Join(s.dir, "sids", hashName(sid))
+}
+
+func (s *fileSessionStore) Get(id string) (*Session, error) {
+	data, err := ioutil.ReadFile(s.sessionFile(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored fileSession
+	if err = json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		_ = s.Delete(id)
+		return nil, ErrNoSession
+	}
+	return stored.Session, nil
+}
+
+func (s *fileSessionStore) Save(session *Session, ttl time.Duration) error {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) >= s.cleanupInterval {
+		s.lastSweep = time.Now()
+		go s.sweep()
+	}
+	s.mu.Unlock()
+
+	data, err := json.Marshal(fileSession{Session: session, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Join(s.dir, "sessions"), "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), s.sessionFile(session.ID)); err != nil {
+		return err
+	}
+	if session.Sid == "" {
+		return nil
+	}
+	if err = os.MkdirAll(s.sidDir(session.Sid), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.sidDir(session.Sid), hashName(session.ID)), []byte(session.ID), 0600)
+}
+
+func (s *fileSessionStore) Delete(id string) error {
+	err := os.Remove(s.sessionFile(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileSessionStore) DeleteBySid(sid string) error {
+	entries, err := ioutil.ReadDir(s.sidDir(sid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		id, err := ioutil.ReadFile(filepath.Join(s.sidDir(sid), entry.Name()))
+		if err != nil {
+			return err
+		}
+		if err = s.Delete(string(id)); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(s.sidDir(sid))
+}
+
+// sweep removes expired sessions and the sid index entries of removed sessions
+func (s *fileSessionStore) sweep() {
+	sessionsDir := filepath.Join(s.dir, "sessions")
+	entries, err := ioutil.ReadDir(sessionsDir)
+	if err != nil {
+		glog.Errorf("[Gin-OAuth] Can not sweep sessions, caused by: %s", err)
+		return
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "tmp-") {
+			continue
+		}
+		file := filepath.Join(sessionsDir, entry.Name())
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var stored fileSession
+		if json.Unmarshal(data, &stored) != nil || time.Now().After(stored.ExpiresAt) {
+			_ = os.Remove(file)
+		}
+	}
+
+	// index entries are named like the session file they point to
+	sidDirs, err := ioutil.ReadDir(filepath.Join(s.dir, "sids"))
+	if err != nil {
+		return
+	}
+	for _, sidDir := range sidDirs {
+		dir := filepath.Join(s.dir, "sids", sidDir.Name())
+		links, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, link := range links {
+			if _, err = os.Stat(filepath.Join(sessionsDir, link.Name())); os.IsNotExist(err) {
+				_ = os.Remove(filepath.Join(dir, link.Name()))
+			}
+		}
+		// fails unless the directory is empty
+		_ = os.Remove(dir)
+	}
+}
+
+// RedisSessionClient is the subset of a Redis client used by the Redis session store,
+// wrap e.g. go-redis to implement it
+type RedisSessionClient interface {
+	// Get returns nil without error if the key does not exist
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Del(keys ...string) error
+	// SAdd adds member to the set at key and sets the expiry of the set to ttl
+	SAdd(key string, member string, ttl time.Duration) error
+	SMembers(key string) ([]string, error)
+}
+
+type redisSessionStore struct {
+	client RedisSessionClient
+	prefix string
+}
+
+func NewRedisSessionStore(client RedisSessionClient, prefix string) SessionStore {
+	return &redisSessionStore{client: client, prefix: prefix}
+}
+
+func (s *redisSessionStore) Get(id string) (*Session, error) {
+	data, err := s.client.Get(s.prefix + "session:" + id)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrNoSession
+	}
+	var session Session
+	if err = json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *redisSessionStore) Save(session *Session, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	if err = s.client.Set(s.prefix+"session:"+session.ID, data, ttl); err != nil {
+		return err
+	}
+	if session.Sid == "" {
+		return nil
+	}
+	return s.client.SAdd(s.prefix+"sid:"+session.Sid, session.ID, ttl)
+}
+
+func (s *redisSessionStore) Delete(id string) error {
+	return s.client.Del(s.prefix + "session:" + id)
+}
+
+func (s *redisSessionStore) DeleteBySid(sid string) error {
+	ids, err := s.client.SMembers(s.prefix + "sid:" + sid)
+	if err != nil {
+		return err
+	}
+	keys := []string{s.prefix + "sid:" + sid}
+	for _, id := range ids {
+		keys = append(keys, s.prefix+"session:"+id)
+	}
+	return s.client.Del(keys...)
+}
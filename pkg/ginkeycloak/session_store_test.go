@@ -0,0 +1,143 @@
+package ginkeycloak
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRedisSessions struct {
+	values map[string][]byte
+	sets   map[string][]string
+}
+
+func (r *fakeRedisSessions) Get(key string) ([]byte, error) {
+	return r.values[key], nil
+}
+
+func (r *fakeRedisSessions) Set(key string, value []byte, ttl time.Duration) error {
+	r.values[key] = value
+	return nil
+}
+
+func (r *fakeRedisSessions) Del(keys ...string) error {
+	for _, key := range keys {
+		delete(r.values, key)
+		delete(r.sets, key)
+	}
+	return nil
+}
+
+func (r *fakeRedisSessions) SAdd(key string, member string, ttl time.Duration) error {
+	r.sets[key] = append(r.sets[key], member)
+	return nil
+}
+
+func (r *fakeRedisSessions) SMembers(key string) ([]string, error) {
+	return r.sets[key], nil
+}
+
+func testSessionStore(t *testing.T, store SessionStore) {
+	assert.NoError(t, store.Save(&Session{ID: "a", AccessToken: "token-a", Sid: "sso-1"}, time.Minute))
+	assert.NoError(t, store.Save(&Session{ID: "b", AccessToken: "token-b", Sid: "sso-1"}, time.Minute))
+	assert.NoError(t, store.Save(&Session{ID: "c", AccessToken: "token-c", Sid: "sso-2"}, time.Minute))
+
+	session, err := store.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-a", session.AccessToken)
+
+	assert.NoError(t, store.DeleteBySid("sso-1"))
+	_, err = store.Get("a")
+	assert.Equal(t, ErrNoSession, err)
+	_, err = store.Get("b")
+	assert.Equal(t, ErrNoSession, err)
+	_, err = store.Get("c")
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Delete("c"))
+	_, err = store.Get("c")
+	assert.Equal(t, ErrNoSession, err)
+}
+
+func Test_SessionStore_memory(t *testing.T) {
+	store := NewMemorySessionStore(time.Minute)
+	testSessionStore(t, store)
+
+	assert.NoError(t, store.Save(&Session{ID: "expiring"}, 10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	_, err := store.Get("expiring")
+	assert.Equal(t, ErrNoSession, err)
+}
+
+func Test_SessionStore_file(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sessions")
+	defer os.RemoveAll(dir)
+	store, err := NewFileSessionStore(dir, time.Hour)
+	assert.NoError(t, err)
+	testSessionStore(t, store)
+
+	assert.NoError(t, store.Save(&Session{ID: "expiring"}, -time.Second))
+	_, err = store.Get("expiring")
+	assert.Equal(t, ErrNoSession, err)
+}
+
+func Test_SessionStore_file_sweep(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sessions")
+	defer os.RemoveAll(dir)
+	store, err := NewFileSessionStore(dir, time.Hour)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Save(&Session{ID: "expired", Sid: "sso-1"}, -time.Second))
+	assert.NoError(t, store.Save(&Session{ID: "active", Sid: "sso-2"}, time.Minute))
+	store.(*fileSessionStore).sweep()
+
+	sessions, _ := ioutil.ReadDir(filepath.Join(dir, "sessions"))
+	assert.Equal(t, 1, len(sessions))
+	sids, _ := ioutil.ReadDir(filepath.Join(dir, "sids"))
+	assert.Equal(t, 1, len(sids))
+	_, err = store.Get("active")
+	assert.NoError(t, err)
+}
+
+func Test_SessionStore_redis(t *testing.T) {
+	testSessionStore(t, NewRedisSessionStore(&fakeRedisSessions{values: map[string][]byte{}, sets: map[string][]string{}}, "app:"))
+}
+
+func Test_Login_with_store_and_backchannel_logout(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	store := NewMemorySessionStore(time.Minute)
+	login, router := newTestLogin(t, kc, func(config *LoginConfig) {
+		config.SessionStore = store
+	})
+	router.POST("/backchannel-logout", login.BackchannelLogoutHandler())
+
+	sessionCookie := loginFlow(t, kc, router)
+	assert.True(t, len(sessionCookie.Value) < 100)
+	resp := serve(router, "/private", []*http.Cookie{sessionCookie})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	logout := signRSAToken(map[string]interface{}{
+		"iss":    kc.issuer(),
+		"aud":    loginClientID,
+		"iat":    time.Now().Unix(),
+		"sid":    kc.sid,
+		"events": map[string]interface{}{backchannelLogoutEvent: map[string]interface{}{}},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/backchannel-logout", strings.NewReader(url.Values{"logout_token": {logout}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	logoutResp := httptest.NewRecorder()
+	router.ServeHTTP(logoutResp, req)
+	assert.Equal(t, http.StatusOK, logoutResp.Code)
+
+	resp = serve(router, "/private", []*http.Cookie{sessionCookie})
+	assert.Equal(t, http.StatusFound, resp.Code)
+}
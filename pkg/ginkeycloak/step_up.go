@@ -0,0 +1,87 @@
+package ginkeycloak
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StepUpConfig describes the authentication strength a route requires (RFC 9470)
+type StepUpConfig struct {
+	// AcrValues orders the acr values from weakest to strongest, e.g. []string{"bronze", "silver", "gold"}.
+	// If empty, acr values are compared as Keycloak levels of authentication ("0", "1", "2", ...)
+	AcrValues []string
+	MinAcr    string
+	MaxAge    time.Duration
+}
+
+func (config StepUpConfig) acrLevel(acr string) (int, bool) {
+	if len(config.AcrValues) == 0 {
+		level, err := strconv.Atoi(acr)
+		return level, err == nil
+	}
+	for idx, value := range config.AcrValues {
+		if value == acr {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+func (config StepUpConfig) acrSatisfied(acr string) bool {
+	if config.MinAcr == "" {
+		return true
+	}
+	required, ok := config.acrLevel(config.MinAcr)
+	if !ok {
+		return false
+	}
+	actual, ok := config.acrLevel(acr)
+	return ok && actual >= required
+}
+
+func (config StepUpConfig) authTimeSatisfied(authTime int64) bool {
+	if config.MaxAge <= 0 {
+		return true
+	}
+	if authTime == 0 {
+		return false
+	}
+	return time.Since(time.Unix(authTime, 0)) <= config.MaxAge
+}
+
+func (config StepUpConfig) challenge() string {
+	params := []string{
+		`error="insufficient_user_authentication"`,
+		`error_description="A different authentication level is required"`,
+	}
+	if config.MinAcr != "" {
+		params = append(params, fmt.Sprintf(`acr_values="%s"`, config.MinAcr))
+	}
+	if config.MaxAge > 0 {
+		params = append(params, fmt.Sprintf(`max_age="%d"`, int64(config.MaxAge/time.Second)))
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// StepUpCheck grants access only if inner grants it and the token additionally satisfies the
+// authentication requirements. Tokens with a weaker acr or an older auth_time than required are
+// rejected with a 401 challenge so clients can re-authenticate
+func StepUpCheck(config StepUpConfig, inner AccessCheckFunction) func(tc *TokenContainer, ctx *gin.Context) bool {
+	return func(tc *TokenContainer, ctx *gin.Context) bool {
+		if !inner(tc, ctx) {
+			return false
+		}
+		if config.acrSatisfied(tc.KeyCloakToken.Acr) && config.authTimeSatisfied(tc.KeyCloakToken.AuthTime) {
+			return true
+		}
+		ctx.Header("WWW-Authenticate", config.challenge())
+		_ = ctx.AbortWithError(http.StatusUnauthorized, errors.New("Insufficient user authentication"))
+		return false
+	}
+}
@@ -0,0 +1,80 @@
+package ginkeycloak
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func stepUpToken(acr string, authTime time.Time) string {
+	token := createToken(time.Now().Add(time.Minute))
+	token.Acr = acr
+	token.AuthTime = authTime.Unix()
+	return signRSAToken(token)
+}
+
+func Test_StepUp_sufficient_acr(t *testing.T) {
+	authFunc := NewAccessBuilder(builderConfiig).
+		RestrictButForRole(validRole).
+		RequireAcr("1").
+		Build()
+
+	ctx := buildContext(stepUpToken("2", time.Now()))
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 0)
+}
+
+func Test_StepUp_insufficient_acr(t *testing.T) {
+	config := builderConfiig
+	config.AcrValues = []string{"bronze", "silver", "gold"}
+	authFunc := NewAccessBuilder(config).
+		RestrictButForRole(validRole).
+		RequireAcr("gold").
+		Build()
+
+	ctx := buildContext(stepUpToken("silver", time.Now()))
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.Equal(t, http.StatusUnauthorized, ctx.Writer.Status())
+	assert.Equal(t, `Bearer error="insufficient_user_authentication", error_description="A different authentication level is required", acr_values="gold"`,
+		ctx.Writer.Header().Get("WWW-Authenticate"))
+}
+
+func Test_StepUp_authentication_too_old(t *testing.T) {
+	authFunc := Auth(StepUpCheck(StepUpConfig{MaxAge: 5 * time.Minute}, AuthCheck()), KeycloakConfig{})
+
+	ctx := buildContext(stepUpToken("1", time.Now().Add(-10*time.Minute)))
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.Equal(t, http.StatusUnauthorized, ctx.Writer.Status())
+	assert.Contains(t, ctx.Writer.Header().Get("WWW-Authenticate"), `max_age="300"`)
+}
+
+func Test_StepUp_missing_role_is_forbidden(t *testing.T) {
+	authFunc := NewAccessBuilder(builderConfiig).
+		RestrictButForRole(invalidRole).
+		RequireAcr("1").
+		Build()
+
+	ctx := buildContext(stepUpToken("0", time.Now()))
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.Equal(t, "Access to the Resource is forbidden", ctx.Errors[0].Err.Error())
+}
+
+func Test_StepUp_check_requires_inner_check(t *testing.T) {
+	authFunc := Auth(StepUpCheck(StepUpConfig{MinAcr: "1"}, RealmCheck([]string{"admin"})), KeycloakConfig{})
+
+	ctx := buildContext(stepUpToken("2", time.Now()))
+	authFunc(ctx)
+
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.Equal(t, http.StatusForbidden, ctx.Writer.Status())
+	assert.Empty(t, ctx.Writer.Header().Get("WWW-Authenticate"))
+}
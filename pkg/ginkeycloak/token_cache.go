@@ -0,0 +1,138 @@
+package ginkeycloak
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// TokenCache remembers verified tokens until they expire, so tokens used for many requests are
+// parsed and verified only once. Token type, expiry, revocation, DPoP, certificate binding and
+// one-time-use checks still run on every request. Removing a key from the realm or KeySource does
+// not invalidate tokens cached before, call Flush after key rotation.
+type TokenCache struct {
+	// hits and misses are accessed atomically and kept first for 64-bit alignment
+	hits   uint64
+	misses uint64
+	cache  *lruCache
+}
+
+type tokenCacheEntry struct {
+	token      *KeyCloakToken
+	headerType string
+}
+
+var tokenCacheScopes uint64
+
+// newTokenCacheScope is called once per middleware, a cache shared by middlewares with different
+// keys or claim mappers never returns tokens verified by another
+func newTokenCacheScope() uint64 {
+	return atomic.AddUint64(&tokenCacheScopes, 1)
+}
+
+// middlewareTokenCache returns the cache for tokens verified by a middleware, others are not cached
+func middlewareTokenCache(config KeycloakConfig) *TokenCache {
+	if config.tokenCacheScope == 0 {
+		return nil
+	}
+	return config.TokenCache
+}
+
+// NewTokenCache keeps at most maxEntries tokens, the least recently used are evicted first
+func NewTokenCache(maxEntries int) *TokenCache {
+	return &TokenCache{cache: newLRUCache(maxEntries)}
+}
+
+// Hits returns how many tokens were found in the cache
+func (c *TokenCache) Hits() uint64 {
+	return atomic.LoadUint64(&c.hits)
+}
+
+// Misses returns how many tokens had to be verified
+func (c *TokenCache) Misses() uint64 {
+	return atomic.LoadUint64(&c.misses)
+}
+
+// Len returns the number of cached tokens
+func (c *TokenCache) Len() int {
+	return c.cache.len()
+}
+
+// Flush drops all cached tokens, e.g. after a key has been removed
+func (c *TokenCache) Flush() {
+	c.cache.clear()
+}
+
+// tokenCacheKey hashes the raw token, a token verified for one realm or middleware is not reused for another
+func tokenCacheKey(rawToken string, config KeycloakConfig) string {
+	sum := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(sum[:]) + "|" + strconv.FormatUint(config.tokenCacheScope, 10) + "|" + config.Url + "|" + config.Realm
+}
+
+func (c *TokenCache) get(rawToken string, config KeycloakConfig) (*KeyCloakToken, string, bool) {
+	cached, ok := c.cache.get(tokenCacheKey(rawToken, config))
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, "", false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	entry := cached.(tokenCacheEntry)
+	return copyKeyCloakToken(entry.token), entry.headerType, true
+}
+
+func (c *TokenCache) set(rawToken string, config KeycloakConfig, token *KeyCloakToken, headerType string) {
+	if token.Exp == 0 {
+		return
+	}
+	entry := tokenCacheEntry{token: copyKeyCloakToken(token), headerType: headerType}
+	c.cache.set(tokenCacheKey(rawToken, config), entry, time.Unix(token.Exp, 0))
+}
+
+// copyKeyCloakToken deep copies the token, so changes of a request do not reach the cache or other requests
+func copyKeyCloakToken(token *KeyCloakToken) *KeyCloakToken {
+	copied := *token
+	copied.AllowedOrigins = copyStrings(token.AllowedOrigins)
+	copied.RealmAccess.Roles = copyStrings(token.RealmAccess.Roles)
+	if token.ResourceAccess != nil {
+		copied.ResourceAccess = make(map[string]ServiceRole, len(token.ResourceAccess))
+		for client, serviceRole := range token.ResourceAccess {
+			copied.ResourceAccess[client] = ServiceRole{Roles: copyStrings(serviceRole.Roles)}
+		}
+	}
+	if token.Cnf != nil {
+		cnf := *token.Cnf
+		copied.Cnf = &cnf
+	}
+	copied.CustomClaims = copyClaim(token.CustomClaims)
+	return &copied
+}
+
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	return append([]string(nil), values...)
+}
+
+// copyClaim copies the maps and slices of JSON claims, other custom claim types are shared
+func copyClaim(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		claims := make(map[string]interface{}, len(v))
+		for claim, claimValue := range v {
+			claims[claim] = copyClaim(claimValue)
+		}
+		return claims
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for idx, element := range v {
+			values[idx] = copyClaim(element)
+		}
+		return values
+	case []string:
+		return copyStrings(v)
+	}
+	return value
+}
@@ -0,0 +1,154 @@
+package ginkeycloak
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TokenCache(t *testing.T) {
+	cache := NewTokenCache(10)
+	authFunc := Auth(AuthCheck(), KeycloakConfig{TokenCache: cache})
+
+	for i := 0; i < 3; i++ {
+		ctx := buildContext(tokens[0])
+		authFunc(ctx)
+		assert.True(t, len(ctx.Errors) == 0)
+	}
+	assert.Equal(t, uint64(1), cache.Misses())
+	assert.Equal(t, uint64(2), cache.Hits())
+	assert.Equal(t, 1, cache.Len())
+
+	ctx := buildContext(tokens[0])
+	Auth(AuthCheck(), KeycloakConfig{Realm: "other", TokenCache: cache})(ctx)
+	assert.True(t, len(ctx.Errors) == 1, "tokens are cached per realm")
+	assert.Equal(t, uint64(2), cache.Misses())
+}
+
+func Test_TokenCache_returns_copies(t *testing.T) {
+	cache := NewTokenCache(10)
+	token := createToken(time.Now().Add(time.Minute))
+	token.CustomClaims = map[string]interface{}{"tenant": "acme"}
+	cache.set("raw", KeycloakConfig{}, &token, "JWT")
+	token.Name = "changed"
+
+	cached, headerType, ok := cache.get("raw", KeycloakConfig{})
+	assert.True(t, ok)
+	assert.Equal(t, "JWT", headerType)
+	assert.Empty(t, cached.Name)
+	cached.CustomClaims.(map[string]interface{})["tenant"] = "other"
+	cached, _, _ = cache.get("raw", KeycloakConfig{})
+	assert.Equal(t, "acme", cached.CustomClaims.(map[string]interface{})["tenant"])
+}
+
+func Test_TokenCache_request_changes_do_not_reach_cache(t *testing.T) {
+	cache := NewTokenCache(10)
+	token := createToken(time.Now().Add(time.Minute))
+	token.AllowedOrigins = []string{"https://app.example.com"}
+	token.CustomClaims = map[string]interface{}{"groups": []interface{}{"sales"}}
+	raw := signRSAToken(token)
+	var seen []KeyCloakToken
+	authFunc := Auth(func(tc *TokenContainer, ctx *gin.Context) bool {
+		seen = append(seen, *copyKeyCloakToken(tc.KeyCloakToken))
+		tc.KeyCloakToken.RealmAccess.Roles[0] = "admin"
+		tc.KeyCloakToken.ResourceAccess[serviceName].Roles[0] = "admin"
+		tc.KeyCloakToken.AllowedOrigins[0] = "https://evil.example.com"
+		tc.KeyCloakToken.CustomClaims.(map[string]interface{})["groups"].([]interface{})[0] = "admin"
+		return true
+	}, KeycloakConfig{TokenCache: cache})
+
+	for i := 0; i < 3; i++ {
+		ctx := buildContext(raw)
+		authFunc(ctx)
+		assert.True(t, len(ctx.Errors) == 0)
+	}
+
+	assert.Equal(t, uint64(2), cache.Hits())
+	for _, token := range seen {
+		assert.Equal(t, seen[0], token)
+		assert.NotContains(t, token.RealmAccess.Roles, "admin")
+	}
+}
+
+func Test_TokenCache_expiry_and_bounds(t *testing.T) {
+	cache := NewTokenCache(2)
+	expired := createToken(time.Now().Add(-time.Second))
+	cache.set("expired", KeycloakConfig{}, &expired, "")
+	_, _, ok := cache.get("expired", KeycloakConfig{})
+	assert.False(t, ok)
+
+	valid := createToken(time.Now().Add(time.Minute))
+	for _, raw := range []string{"a", "b", "c"} {
+		cache.set(raw, KeycloakConfig{}, &valid, "")
+	}
+	assert.Equal(t, 2, cache.Len())
+	_, _, ok = cache.get("a", KeycloakConfig{})
+	assert.False(t, ok)
+
+	cache.Flush()
+	assert.Equal(t, 0, cache.Len())
+}
+
+func Test_TokenCache_revocation(t *testing.T) {
+	config := KeycloakConfig{Realm: "test", Revocations: NewRevocationStore(), TokenCache: NewTokenCache(10)}
+	cacheTestKeys(config)
+	token := createToken(time.Now().Add(time.Minute))
+	token.Iat = time.Now().Add(-time.Minute).Unix()
+	signed := signRSAToken(token)
+	authFunc := Auth(AuthCheck(), config)
+
+	ctx := buildContext(signed)
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+
+	config.Revocations.SetNotBefore("test", time.Now().Unix())
+	ctx = buildContext(signed)
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrTokenRevoked))
+	assert.Equal(t, uint64(1), config.TokenCache.Hits())
+}
+
+func Test_TokenCache_concurrent(t *testing.T) {
+	config := KeycloakConfig{TokenCache: NewTokenCache(10)}
+	authFunc := Auth(AuthCheck(), config)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := buildContext(tokens[1])
+			authFunc(ctx)
+			assert.True(t, len(ctx.Errors) == 0)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(20), config.TokenCache.Hits()+config.TokenCache.Misses())
+}
+
+func Test_TokenCache_per_middleware(t *testing.T) {
+	cache := NewTokenCache(10)
+	bearerOnly := Auth(AuthCheck(), KeycloakConfig{TokenCache: cache})
+	idOnly := Auth(AuthCheck(), KeycloakConfig{TokenCache: cache, AllowedTokenTypes: []string{"ID"}})
+
+	for i := 0; i < 2; i++ {
+		ctx := buildContext(tokens[0])
+		bearerOnly(ctx)
+		assert.True(t, len(ctx.Errors) == 0)
+
+		ctx = buildContext(tokens[0])
+		idOnly(ctx)
+		assert.True(t, len(ctx.Errors) == 1)
+		assert.True(t, errors.Is(ctx.Errors[0].Err, ErrInvalidTokenType), "the typ check runs on cache hits")
+	}
+	assert.Equal(t, uint64(2), cache.Hits())
+	assert.Equal(t, 2, cache.Len())
+
+	_, err := GetTokenContainer(tokenFromString(tokens[0]), KeycloakConfig{TokenCache: cache})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), cache.Hits()+cache.Misses()-2, "GetTokenContainer does not use the cache")
+}
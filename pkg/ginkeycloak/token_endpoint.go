@@ -0,0 +1,65 @@
+package ginkeycloak
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenRequestTimeout limits every request to the token endpoint
+var TokenRequestTimeout = 10 * time.Second
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// postTokenRequest sends a request to the token endpoint, the client authenticates with basic
+// auth if clientSecret is set, otherwise the params have to authenticate it
+func postTokenRequest(ctx context.Context, httpClient *http.Client, tokenURL string, params url.Values, clientID string, clientSecret string) (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(ctx, TokenRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readResponse(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result tokenResponse
+	if err = json.Unmarshal(body, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return nil, fmt.Errorf("status %d %s %s", resp.StatusCode, result.Error, result.ErrorDescription)
+	}
+
+	token := &oauth2.Token{AccessToken: result.AccessToken, TokenType: result.TokenType}
+	if result.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
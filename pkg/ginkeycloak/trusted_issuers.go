@@ -0,0 +1,69 @@
+package ginkeycloak
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUntrustedIssuer = errors.New("Token issuer is not trusted")
+
+// TrustedIssuer is a Keycloak realm whose tokens are accepted in addition to others, e.g. the old
+// and the new server during a migration
+type TrustedIssuer struct {
+	// Issuer is the iss claim of the tokens, defaults to the realm URL of Url and Realm
+	Issuer        string
+	Url           string
+	Realm         string
+	FullCertsPath *string
+	// KeySource provides the keys of the issuer, defaults to the certs endpoint of the realm
+	KeySource KeySource
+	// CustomClaimsMapper replaces the mapper of the KeycloakConfig for tokens of this issuer
+	CustomClaimsMapper ClaimMapperFunc
+}
+
+// trustedIssuerConfig selects the trusted issuer by the unverified iss claim of the token and
+// returns the config to verify the token with. Configs without TrustedIssuers are returned as they are.
+func trustedIssuerConfig(rawToken string, config KeycloakConfig) (KeycloakConfig, error) {
+	issuerConfig, _, err := trustedIssuer(rawToken, config)
+	return issuerConfig, err
+}
+
+// trustedIssuer is trustedIssuerConfig that also returns the expected iss of the token,
+// which is empty for configs without TrustedIssuers
+func trustedIssuer(rawToken string, config KeycloakConfig) (KeycloakConfig, string, error) {
+	if len(config.TrustedIssuers) == 0 {
+		return config, "", nil
+	}
+	parsedJWT, err := parseSignedToken(rawToken, config)
+	if err != nil {
+		return config, "", err
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+	}
+	if err = parsedJWT.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return config, "", err
+	}
+
+	for _, trusted := range config.TrustedIssuers {
+		issuerConfig := config
+		issuerConfig.TrustedIssuers = nil
+		issuerConfig.Url = trusted.Url
+		issuerConfig.Realm = trusted.Realm
+		issuerConfig.FullCertsPath = trusted.FullCertsPath
+		issuerConfig.KeySource = trusted.KeySource
+		if trusted.CustomClaimsMapper != nil {
+			issuerConfig.CustomClaimsMapper = trusted.CustomClaimsMapper
+		}
+		issuer := trusted.Issuer
+		if issuer == "" {
+			if issuer, err = realmURL(issuerConfig); err != nil {
+				return config, "", err
+			}
+		}
+		if claims.Iss == issuer {
+			return issuerConfig, issuer, nil
+		}
+	}
+	return config, "", fmt.Errorf("%w: %s", ErrUntrustedIssuer, claims.Iss)
+}
@@ -0,0 +1,66 @@
+package ginkeycloak
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+)
+
+func Test_TrustedIssuers(t *testing.T) {
+	cacheTestKeys(KeycloakConfig{Url: "https://old.example.com", Realm: "shop"})
+	cacheTestKeys(KeycloakConfig{Url: "https://new.example.com", Realm: "shop"})
+	config := KeycloakConfig{TrustedIssuers: []TrustedIssuer{
+		{Url: "https://old.example.com", Realm: "shop"},
+		{
+			Issuer: "https://login.example.com/realms/shop",
+			Url:    "https://new.example.com",
+			Realm:  "shop",
+			CustomClaimsMapper: func(jsonWebToken *jwt.JSONWebToken, keyCloakToken *KeyCloakToken) error {
+				keyCloakToken.CustomClaims = "migrated"
+				return nil
+			},
+		},
+	}}
+	authFunc := Auth(AuthCheck(), config)
+
+	ctx := buildContext(realmToken("https://old.example.com/realms/shop"))
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+	tokenContainer, _ := TokenContainerFromContext(ctx)
+	assert.Nil(t, tokenContainer.KeyCloakToken.CustomClaims)
+
+	ctx = buildContext(realmToken("https://login.example.com/realms/shop"))
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+	tokenContainer, _ = TokenContainerFromContext(ctx)
+	assert.Equal(t, "migrated", tokenContainer.KeyCloakToken.CustomClaims)
+
+	ctx = buildContext(realmToken("https://new.example.com/realms/other"))
+	authFunc(ctx)
+	assert.True(t, len(ctx.Errors) == 1)
+	assert.True(t, errors.Is(ctx.Errors[0].Err, ErrUntrustedIssuer))
+}
+
+func Test_TrustedIssuers_keys_per_issuer(t *testing.T) {
+	cacheTestKeys(KeycloakConfig{Url: "https://old.example.com", Realm: "shop"})
+	config := KeycloakConfig{TrustedIssuers: []TrustedIssuer{
+		{Url: "https://old.example.com", Realm: "shop"},
+		{Url: "http://127.0.0.1:1", Realm: "shop"},
+	}}
+
+	_, err := GetTokenContainer(tokenFromString(realmToken("http://127.0.0.1:1/realms/shop")), config)
+	assert.Error(t, err, "keys of the old issuer are not used for the new one")
+}
+
+func Test_TrustedIssuers_resolved_config(t *testing.T) {
+	cacheTestKeys(KeycloakConfig{Url: "https://old.example.com", Realm: "shop"})
+	config := KeycloakConfig{Realm: "default", TrustedIssuers: []TrustedIssuer{{Url: "https://old.example.com", Realm: "shop"}}}
+
+	tc, issuerConfig, err := resolveTokenContainer(tokenFromString(realmToken("https://old.example.com/realms/shop")), config)
+	assert.NoError(t, err)
+	assert.NotNil(t, tc)
+	assert.Equal(t, "shop", issuerConfig.Realm)
+	assert.Empty(t, issuerConfig.TrustedIssuers)
+}
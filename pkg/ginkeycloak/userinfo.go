@@ -0,0 +1,117 @@
+package ginkeycloak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/oauth2"
+)
+
+// DefaultUserInfoTTL is used if NewUserInfoEnricher gets no ttl
+var DefaultUserInfoTTL = 5 * time.Minute
+
+// UserInfoEnricher completes tokens with the claims of the userinfo endpoint of the realm
+type UserInfoEnricher struct {
+	cache *cache.Cache
+}
+
+// NewUserInfoEnricher caches the userinfo of every subject for ttl
+func NewUserInfoEnricher(ttl time.Duration) *UserInfoEnricher {
+	if ttl <= 0 {
+		ttl = DefaultUserInfoTTL
+	}
+	return &UserInfoEnricher{cache: cache.New(ttl, ttl)}
+}
+
+// enrich fills standard claims missing in the token and adds the other userinfo claims to
+// CustomClaims if these are unset or a map. Failures are logged, the token is used as it is then.
+func (e *UserInfoEnricher) enrich(ctx context.Context, oauthToken *oauth2.Token, token *KeyCloakToken, config KeycloakConfig) {
+	if strings.EqualFold(oauthToken.TokenType, dpopScheme) {
+		glog.V(2).Infof("[Gin-OAuth] userinfo not requested for DPoP token of %s", token.Sub)
+		return
+	}
+	userInfo, err := e.userInfo(ctx, oauthToken.AccessToken, token, config)
+	if err != nil {
+		glog.Errorf("[Gin-OAuth] Can not get userinfo, caused by: %s", err)
+		return
+	}
+
+	for claim, field := range map[string]*string{
+		"name":               &token.Name,
+		"preferred_username": &token.PreferredUsername,
+		"given_name":         &token.GivenName,
+		"family_name":        &token.FamilyName,
+		"email":              &token.Email,
+	} {
+		if value, ok := userInfo[claim].(string); ok && *field == "" {
+			*field = value
+		}
+	}
+
+	if token.CustomClaims == nil {
+		token.CustomClaims = map[string]interface{}{}
+	}
+	customClaims, ok := token.CustomClaims.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for claim, value := range userInfo {
+		if _, exists := customClaims[claim]; !exists {
+			customClaims[claim] = value
+		}
+	}
+}
+
+func (e *UserInfoEnricher) userInfo(ctx context.Context, accessToken string, token *KeyCloakToken, config KeycloakConfig) (map[string]interface{}, error) {
+	key := token.Iss + "|" + token.Sub
+	if cached, ok := e.cache.Get(key); ok {
+		return cached.(map[string]interface{}), nil
+	}
+
+	metadata, err := GetRealmMetadata(config)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.UserinfoEndpoint == "" {
+		return nil, errors.New("realm has no userinfo_endpoint")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadata.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := http.DefaultClient
+	if config.HTTPClient != nil {
+		httpClient = config.HTTPClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %d", metadata.UserinfoEndpoint, resp.StatusCode)
+	}
+	body, err := readResponse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", metadata.UserinfoEndpoint, err)
+	}
+	var userInfo map[string]interface{}
+	if err = json.Unmarshal(body, &userInfo); err != nil {
+		return nil, err
+	}
+	if userInfo["sub"] != token.Sub {
+		return nil, fmt.Errorf("userinfo sub %v does not match token sub %s", userInfo["sub"], token.Sub)
+	}
+	e.cache.Set(key, userInfo, cache.DefaultExpiration)
+	return userInfo, nil
+}
@@ -0,0 +1,59 @@
+package ginkeycloak
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_UserInfo_enrichment(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	config := KeycloakConfig{Url: kc.server.URL, Realm: "test", UserInfo: NewUserInfoEnricher(time.Minute)}
+	token := createToken(time.Now().Add(time.Minute))
+	token.Sub = "user"
+	token.Email = "token@example.com"
+
+	for i := 0; i < 2; i++ {
+		ctx := buildContext(signRSAToken(token))
+		Auth(AuthCheck(), config)(ctx)
+		assert.True(t, len(ctx.Errors) == 0)
+
+		tokenContainer, _ := TokenContainerFromContext(ctx)
+		assert.Equal(t, "Jane Doe", tokenContainer.KeyCloakToken.Name)
+		assert.Equal(t, "token@example.com", tokenContainer.KeyCloakToken.Email)
+		assert.Equal(t, "sales", tokenContainer.KeyCloakToken.CustomClaims.(map[string]interface{})["department"])
+	}
+	assert.Equal(t, 1, kc.userInfoCalls)
+}
+
+func Test_UserInfo_subject_mismatch(t *testing.T) {
+	kc := newFakeKeycloak()
+	defer kc.server.Close()
+	config := KeycloakConfig{Url: kc.server.URL, Realm: "test", UserInfo: NewUserInfoEnricher(time.Minute)}
+	token := createToken(time.Now().Add(time.Minute))
+	token.Sub = "other"
+
+	ctx := buildContext(signRSAToken(token))
+	Auth(AuthCheck(), config)(ctx)
+	assert.True(t, len(ctx.Errors) == 0)
+	tokenContainer, _ := TokenContainerFromContext(ctx)
+	assert.Empty(t, tokenContainer.KeyCloakToken.Name)
+	assert.Nil(t, tokenContainer.KeyCloakToken.CustomClaims)
+}
+
+func Test_RealmMetadata_size_limit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"issuer": "` + strings.Repeat("a", int(MaxResponseSize)) + `"}`))
+	}))
+	defer server.Close()
+
+	_, err := GetRealmMetadata(KeycloakConfig{Url: server.URL, Realm: "large"})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "response exceeds")
+}
@@ -0,0 +1,87 @@
+package keycloakadmin
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/tbaehler/gin-keycloak/pkg/ginkeycloak"
+)
+
+// GroupCheck allows users which are a direct member of one of the groups, given by name or by
+// path like /staff/admins
+func (c *Client) GroupCheck(groups ...string) ginkeycloak.AccessCheckFunction {
+	return func(tc *ginkeycloak.TokenContainer, ctx *gin.Context) bool {
+		userGroups, err := c.GetUserGroups(ctx.Request.Context(), tc.KeyCloakToken.Sub)
+		if err != nil {
+			glog.Errorf("[Gin-OAuth] Can not get groups of %s, caused by: %s", tc.KeyCloakToken.Sub, err)
+			return false
+		}
+		for _, group := range userGroups {
+			for _, allowed := range groups {
+				if group.Path == allowed || (!strings.HasPrefix(allowed, "/") && group.Name == allowed) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// RealmRoleCheck allows users which currently have one of the realm roles, also if it was
+// granted after the token was issued
+func (c *Client) RealmRoleCheck(roles ...string) ginkeycloak.AccessCheckFunction {
+	return func(tc *ginkeycloak.TokenContainer, ctx *gin.Context) bool {
+		userRoles, err := c.GetUserRealmRoles(ctx.Request.Context(), tc.KeyCloakToken.Sub)
+		if err != nil {
+			glog.Errorf("[Gin-OAuth] Can not get realm roles of %s, caused by: %s", tc.KeyCloakToken.Sub, err)
+			return false
+		}
+		return containsRole(userRoles, roles)
+	}
+}
+
+// ClientRoleCheck allows users which currently have one of the roles of the client
+func (c *Client) ClientRoleCheck(clientID string, roles ...string) ginkeycloak.AccessCheckFunction {
+	return func(tc *ginkeycloak.TokenContainer, ctx *gin.Context) bool {
+		userRoles, err := c.GetUserClientRoles(ctx.Request.Context(), tc.KeyCloakToken.Sub, clientID)
+		if err != nil {
+			glog.Errorf("[Gin-OAuth] Can not get roles of %s for client %s, caused by: %s", tc.KeyCloakToken.Sub, clientID, err)
+			return false
+		}
+		return containsRole(userRoles, roles)
+	}
+}
+
+// AttributeCheck allows enabled users with one of the values in the user attribute
+func (c *Client) AttributeCheck(attribute string, values ...string) ginkeycloak.AccessCheckFunction {
+	return func(tc *ginkeycloak.TokenContainer, ctx *gin.Context) bool {
+		user, err := c.GetUser(ctx.Request.Context(), tc.KeyCloakToken.Sub)
+		if err != nil {
+			glog.Errorf("[Gin-OAuth] Can not get user %s, caused by: %s", tc.KeyCloakToken.Sub, err)
+			return false
+		}
+		if !user.Enabled {
+			return false
+		}
+		for _, value := range user.Attributes[attribute] {
+			for _, allowed := range values {
+				if value == allowed {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+func containsRole(userRoles []Role, roles []string) bool {
+	for _, role := range userRoles {
+		for _, allowed := range roles {
+			if role.Name == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+// Package keycloakadmin looks up users, groups and roles with the Keycloak Admin REST API, for
+// access decisions depending on data which is not part of the token.
+package keycloakadmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/tbaehler/gin-keycloak/pkg/ginkeycloak"
+	"golang.org/x/oauth2"
+)
+
+// DefaultCacheTTL is used if Config.CacheTTL is not set
+var DefaultCacheTTL = time.Minute
+
+// MaxResponseSize limits the size of admin API responses
+var MaxResponseSize int64 = 10 << 20
+
+var ErrNotFound = errors.New("Not found")
+
+// Config configures the admin client. The service account of the client needs the view-users
+// and view-clients roles of the realm-management client.
+type Config struct {
+	Credentials ginkeycloak.ClientCredentialsConfig
+	// Realm is the realm to look up, defaults to the realm of the credentials
+	Realm string
+	// CacheTTL is how long responses are reused, defaults to DefaultCacheTTL
+	CacheTTL time.Duration
+}
+
+// Client calls the admin API with tokens of the client credentials grant and caches the responses
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	cache      *cache.Cache
+}
+
+type User struct {
+	ID            string              `json:"id"`
+	Username      string              `json:"username"`
+	Email         string              `json:"email,omitempty"`
+	FirstName     string              `json:"firstName,omitempty"`
+	LastName      string              `json:"lastName,omitempty"`
+	Enabled       bool                `json:"enabled"`
+	EmailVerified bool                `json:"emailVerified"`
+	Attributes    map[string][]string `json:"attributes,omitempty"`
+}
+
+type Group struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type Role struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Composite   bool   `json:"composite"`
+	ClientRole  bool   `json:"clientRole"`
+	ContainerID string `json:"containerId,omitempty"`
+}
+
+type clientRepresentation struct {
+	ID       string `json:"id"`
+	ClientID string `json:"clientId"`
+}
+
+func NewClient(config Config) (*Client, error) {
+	source, err := ginkeycloak.NewClientCredentialsTokenSource(config.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	keycloakConfig := config.Credentials.KeycloakConfig
+	u, err := url.Parse(keycloakConfig.Url)
+	if err != nil {
+		return nil, err
+	}
+	realm := config.Realm
+	if realm == "" {
+		realm = keycloakConfig.Realm
+	}
+	u.Path = path.Join("/", u.Path, "admin/realms", realm)
+	if config.CacheTTL == 0 {
+		config.CacheTTL = DefaultCacheTTL
+	}
+
+	ctx := context.Background()
+	if keycloakConfig.HTTPClient != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, keycloakConfig.HTTPClient)
+	}
+	return &Client{
+		baseURL:    u.String(),
+		httpClient: oauth2.NewClient(ctx, source),
+		cache:      cache.New(config.CacheTTL, 2*config.CacheTTL),
+	}, nil
+}
+
+// FlushCache drops all cached responses, e.g. after an admin event
+func (c *Client) FlushCache() {
+	c.cache.Flush()
+}
+
+// get decodes the response of the admin API path into v, responses are cached by path and query
+func (c *Client) get(ctx context.Context, resource string, query url.Values, v interface{}) error {
+	u := c.baseURL + resource
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	if cached, ok := c.cache.Get(u); ok {
+		return json.Unmarshal(cached.([]byte), v)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: GET %s", ErrNotFound, u)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", u, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxResponseSize))
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return err
+	}
+	c.cache.Set(u, body, cache.DefaultExpiration)
+	return nil
+}
+
+func (c *Client) GetUser(ctx context.Context, userID string) (*User, error) {
+	var user User
+	if err := c.get(ctx, "/users/"+url.PathEscape(userID), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindUser returns the user with exactly this username
+func (c *Client) FindUser(ctx context.Context, username string) (*User, error) {
+	var users []User
+	if err := c.get(ctx, "/users", url.Values{"username": {username}, "exact": {"true"}}, &users); err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: user %s", ErrNotFound, username)
+}
+
+// GetUserGroups returns the groups the user is a direct member of
+func (c *Client) GetUserGroups(ctx context.Context, userID string) ([]Group, error) {
+	var groups []Group
+	err := c.get(ctx, "/users/"+url.PathEscape(userID)+"/groups", nil, &groups)
+	return groups, err
+}
+
+// GetUserRealmRoles returns the effective realm roles of the user, including roles of groups
+// and composite roles
+func (c *Client) GetUserRealmRoles(ctx context.Context, userID string) ([]Role, error) {
+	var roles []Role
+	err := c.get(ctx, "/users/"+url.PathEscape(userID)+"/role-mappings/realm/composite", nil, &roles)
+	return roles, err
+}
+
+// GetUserClientRoles returns the effective roles of the user for the client with this clientId
+func (c *Client) GetUserClientRoles(ctx context.Context, userID string, clientID string) ([]Role, error) {
+	id, err := c.clientUUID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	var roles []Role
+	err = c.get(ctx, "/users/"+url.PathEscape(userID)+"/role-mappings/clients/"+url.PathEscape(id)+"/composite", nil, &roles)
+	return roles, err
+}
+
+// GetRealmRole returns the realm role with this name
+func (c *Client) GetRealmRole(ctx context.Context, name string) (*Role, error) {
+	var role Role
+	if err := c.get(ctx, "/roles/"+url.PathEscape(name), nil, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (c *Client) clientUUID(ctx context.Context, clientID string) (string, error) {
+	var clients []clientRepresentation
+	if err := c.get(ctx, "/clients", url.Values{"clientId": {clientID}}, &clients); err != nil {
+		return "", err
+	}
+	for _, client := range clients {
+		if client.ClientID == clientID {
+			return client.ID, nil
+		}
+	}
+	return "", fmt.Errorf("%w: client %s", ErrNotFound, clientID)
+}
@@ -0,0 +1,129 @@
+package keycloakadmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tbaehler/gin-keycloak/pkg/ginkeycloak"
+)
+
+type fakeAdminAPI struct {
+	server *httptest.Server
+	calls  map[string]int
+}
+
+func newFakeAdminAPI(t *testing.T) *fakeAdminAPI {
+	api := &fakeAdminAPI{calls: map[string]int{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/test/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "admin-token", "token_type": "Bearer", "expires_in": 300})
+	})
+	respond := func(pattern string, value interface{}) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			api.calls[r.URL.Path]++
+			assert.Equal(t, "Bearer admin-token", r.Header.Get("Authorization"))
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(value)
+		})
+	}
+	respond("/admin/realms/test/users/u1", User{ID: "u1", Username: "jane", Enabled: true, Attributes: map[string][]string{"tenant": {"acme"}}})
+	respond("/admin/realms/test/users/u1/groups", []Group{{ID: "g1", Name: "admins", Path: "/staff/admins"}})
+	respond("/admin/realms/test/users/u1/role-mappings/realm/composite", []Role{{ID: "r1", Name: "auditor"}})
+	respond("/admin/realms/test/users/u1/role-mappings/clients/c-uuid/composite", []Role{{ID: "r2", Name: "orders:write", ClientRole: true}})
+	respond("/admin/realms/test/clients", []clientRepresentation{{ID: "c-uuid", ClientID: "orders"}})
+	mux.HandleFunc("/admin/realms/test/users", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("exact"))
+		_ = json.NewEncoder(w).Encode([]User{{ID: "u1", Username: r.URL.Query().Get("username")}})
+	})
+	api.server = httptest.NewServer(mux)
+	return api
+}
+
+func newTestClient(t *testing.T, api *fakeAdminAPI) *Client {
+	client, err := NewClient(Config{Credentials: ginkeycloak.ClientCredentialsConfig{
+		KeycloakConfig: ginkeycloak.KeycloakConfig{Url: api.server.URL, Realm: "test"},
+		ClientID:       "admin-cli",
+		ClientSecret:   "secret",
+	}})
+	assert.NoError(t, err)
+	return client
+}
+
+func Test_Lookups(t *testing.T) {
+	api := newFakeAdminAPI(t)
+	defer api.server.Close()
+	client := newTestClient(t, api)
+	ctx := context.Background()
+
+	user, err := client.GetUser(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Equal(t, "jane", user.Username)
+
+	user, err = client.FindUser(ctx, "jane")
+	assert.NoError(t, err)
+	assert.Equal(t, "u1", user.ID)
+
+	groups, err := client.GetUserGroups(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Equal(t, "/staff/admins", groups[0].Path)
+
+	roles, err := client.GetUserRealmRoles(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Equal(t, "auditor", roles[0].Name)
+
+	roles, err = client.GetUserClientRoles(ctx, "u1", "orders")
+	assert.NoError(t, err)
+	assert.Equal(t, "orders:write", roles[0].Name)
+
+	_, err = client.GetUser(ctx, "unknown")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = client.GetUserClientRoles(ctx, "u1", "billing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func Test_Cache(t *testing.T) {
+	api := newFakeAdminAPI(t)
+	defer api.server.Close()
+	client := newTestClient(t, api)
+
+	for i := 0; i < 3; i++ {
+		user, err := client.GetUser(context.Background(), "u1")
+		assert.NoError(t, err)
+		user.Username = "modified"
+	}
+	user, _ := client.GetUser(context.Background(), "u1")
+	assert.Equal(t, "jane", user.Username)
+	assert.Equal(t, 1, api.calls["/admin/realms/test/users/u1"])
+
+	client.FlushCache()
+	_, _ = client.GetUser(context.Background(), "u1")
+	assert.Equal(t, 2, api.calls["/admin/realms/test/users/u1"])
+}
+
+func Test_AccessChecks(t *testing.T) {
+	api := newFakeAdminAPI(t)
+	defer api.server.Close()
+	client := newTestClient(t, api)
+	tc := &ginkeycloak.TokenContainer{KeyCloakToken: &ginkeycloak.KeyCloakToken{Sub: "u1"}}
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	assert.True(t, client.GroupCheck("admins")(tc, ctx))
+	assert.True(t, client.GroupCheck("/staff/admins")(tc, ctx))
+	assert.False(t, client.GroupCheck("/admins")(tc, ctx))
+	assert.True(t, client.RealmRoleCheck("auditor")(tc, ctx))
+	assert.False(t, client.RealmRoleCheck("admin")(tc, ctx))
+	assert.True(t, client.ClientRoleCheck("orders", "orders:write")(tc, ctx))
+	assert.False(t, client.ClientRoleCheck("orders", "orders:delete")(tc, ctx))
+	assert.True(t, client.AttributeCheck("tenant", "acme")(tc, ctx))
+	assert.False(t, client.AttributeCheck("tenant", "other")(tc, ctx))
+
+	unknown := &ginkeycloak.TokenContainer{KeyCloakToken: &ginkeycloak.KeyCloakToken{Sub: "u2"}}
+	assert.False(t, client.GroupCheck("admins")(unknown, ctx))
+}